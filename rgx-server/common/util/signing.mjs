@@ -0,0 +1,62 @@
+'use strict'
+
+import crypto from 'node:crypto'
+import fs from 'node:fs'
+import path from 'node:path'
+import log4js from './log.mjs'
+import tomlConfig from './toml-config.mjs'
+import { configFile, homeDirectory } from './utils.mjs'
+
+const log = log4js.getLogger('signing')
+
+// rgx checks this header against the keys pinned in trusted_keys in its rgx.toml
+const signatureHeader = 'X-Rgx-Signature'
+
+export function keyFile () {
+    const config = tomlConfig(configFile)
+    const f = process.env.RGX_SIGNING_KEY_FILE || config.signing?.private_key_file
+    if (!f) return null
+    return f.includes('{HOME}') ? path.join(homeDirectory(), f.replace('{HOME}', '')) : f
+}
+
+/** The key as rgx.toml pins it, and its id: the first 8 bytes of the sha256 of the key, in hex */
+export function publicKey (privateKey) {
+    const raw = Buffer.from(crypto.createPublicKey(privateKey).export({ format: 'jwk' }).x, 'base64url')
+    return {
+        pinned: `ed25519:${raw.toString('base64')}`,
+        id: crypto.createHash('sha256').update(raw).digest('hex').substring(0, 16)
+    }
+}
+
+function loadKey () {
+    const f = keyFile()
+    if (!f || !fs.existsSync(f)) {
+        log.warn(`no signing key at '${f}', recipes are sent unsigned. Create one with: npm run keygen`)
+        return null
+    }
+    const key = crypto.createPrivateKey(fs.readFileSync(f))
+    if (key.asymmetricKeyType !== 'ed25519') throw new Error(`${f} is not an ed25519 key`)
+    const { pinned, id } = publicKey(key)
+    log.info(`signing recipes with key ${id}: ${pinned}`)
+    return { key, id }
+}
+
+const signingKey = loadKey()
+
+/**
+ * Sends a recipe signed for the request it answers, see RecipeMessage in rgx. The body is
+ * serialised here so that the bytes signed are the bytes sent.
+ */
+export function sendRecipe (res, { pkg, majorVersion, os, arch }, recipe) {
+    const body = JSON.stringify(recipe, null, 2)
+    if (signingKey) {
+        const message = Buffer.concat([
+            Buffer.from(`rgx-recipe ${pkg}/${majorVersion}/${os}/${arch}\n`),
+            Buffer.from(body)
+        ])
+        const signature = crypto.sign(null, message, signingKey.key).toString('base64')
+        res.setHeader(signatureHeader, `${signingKey.id}:${signature}`)
+    }
+    res.type('application/json')
+    return res.send(body)
+}
@@ -1,7 +1,9 @@
 'use-strict'
 
+import crypto from 'node:crypto'
 import fs from 'node:fs'
 import path from 'node:path'
+import { fileURLToPath } from 'node:url'
 import log4js from './log.mjs'
 import tomlConfig from './toml-config.mjs'
 
@@ -22,6 +24,7 @@ if (!configFile) {
     process.exit(1)
 }
 const config = tomlConfig(configFile)
+const staticDir = path.join(path.dirname(fileURLToPath(import.meta.url)), '../../static')
 
 export function appDirs() {
     let dataDir = config.app.data_dir
@@ -54,6 +57,12 @@ function findInHierarchy (basename) {
     return null
 }
 
+/** The checksum rgx verifies a setup script served from /static with, before it runs it */
+export function scriptChecksum (link) {
+    const f = path.join(staticDir, link.replace(/^\/static\//, ''))
+    return 'sha256:' + crypto.createHash('sha256').update(fs.readFileSync(f)).digest('hex')
+}
+
 export function errorText (resp, code, message) {
     resp.setHeader('Content-type', 'text/plain')
     resp.status(code).send(message)
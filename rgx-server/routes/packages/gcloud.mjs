@@ -2,6 +2,7 @@
 
 import express from 'express'
 import * as utils from '../../common/util/utils.mjs'
+import * as signing from '../../common/util/signing.mjs'
 import * as gcloud from '../../services/packages/gcloud.mjs'
 import * as candidates from '../../services/packages/candidates.mjs'
 
@@ -82,7 +83,7 @@ router.get('/release/:majorVersion/:os/:arch', async (req, res) => {
         installation: req.headers['x-rgx-installation']
     })
     if (!r.ok) return utils.errorText(res, r?.code ? r.code : 500, r.error)
-        return res.json(r.data)
+    return signing.sendRecipe(res, { pkg: 'gcloud', majorVersion, os, arch }, r.data)
 })
 
 router.get('/clear-cache', async (req, res) => {
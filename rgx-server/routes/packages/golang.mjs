@@ -2,6 +2,7 @@
 
 import express from 'express'
 import * as utils from '../../common/util/utils.mjs'
+import * as signing from '../../common/util/signing.mjs'
 import * as golang from '../../services/packages/golang.mjs'
 import * as candidates from '../../services/packages/candidates.mjs'
 
@@ -49,6 +50,39 @@ router.get('/versions', async (req, res) => {
     return res.json(r.data)
 })
 
+router.get('/releases', async (req, res) => {
+    /**
+     * @openapi
+     * /packages/golang/releases:
+     *   get:
+     *     summary: Display every release available, e.g. 1.21.13, 1.22.3
+     *     tags: [golang]
+     *     parameters:
+     *       - in: query
+     *         name: os
+     *         type: string
+     *         required: false
+     *         description: Only list releases for this OS -- windows, linux, or macos
+     *       - in: query
+     *         name: arch
+     *         type: string
+     *         required: false
+     *         description: Only list releases for this CPU architecture -- x64 or arm64
+     *     responses:
+     *        200:
+     *          description: A list of releases, oldest first
+     *        500:
+     *          description: Server error
+     */
+
+    const { lts, os, arch } = req.query
+    if (lts) return utils.errorText(res, 400, 'Go does not have LTS versions')
+
+    const r = await golang.releases({ os, arch })
+    if (!r.ok) return utils.errorText(res, 500, r.error)
+    return res.json(r.data)
+})
+
 router.get('/release/:majorVersion/:os/:arch', async (req, res) => {
     /**
      * @openapi
@@ -61,7 +95,7 @@ router.get('/release/:majorVersion/:os/:arch', async (req, res) => {
      *         name: majorVersion
      *         type: float
      *         required: true
-     *         description: The major version of golang required, e.g. 1.21, 1.22
+     *         description: The major version of golang required, e.g. 1.21, 1.22, or an exact release, e.g. 1.22.3
      *       - in: path
      *         name: os
      *         type: string
@@ -84,7 +118,7 @@ router.get('/release/:majorVersion/:os/:arch', async (req, res) => {
         installation: req.headers['x-rgx-installation']
     })
     if (!r.ok) return utils.errorText(res, r?.code ? r.code : 500, r.error)
-        return res.json(r.data)
+    return signing.sendRecipe(res, { pkg: 'golang', majorVersion, os, arch }, r.data)
 })
 
 router.get('/clear-cache', async (req, res) => {
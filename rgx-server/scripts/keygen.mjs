@@ -0,0 +1,23 @@
+'use strict'
+
+// Creates the ed25519 key rgx-server signs recipes with, and prints the line rgx.toml pins it with
+import crypto from 'node:crypto'
+import fs from 'node:fs'
+import path from 'node:path'
+import { keyFile, publicKey } from '../common/util/signing.mjs'
+
+const f = keyFile()
+if (!f) {
+    console.error('set [signing] private_key_file in the service config, or RGX_SIGNING_KEY_FILE')
+    process.exit(1)
+}
+if (fs.existsSync(f)) {
+    console.error(`${f} already exists, not overwriting it`)
+    process.exit(1)
+}
+
+const { privateKey } = crypto.generateKeyPairSync('ed25519')
+fs.mkdirSync(path.dirname(f), { recursive: true })
+fs.writeFileSync(f, privateKey.export({ type: 'pkcs8', format: 'pem' }), { mode: 0o600 })
+console.log(`wrote ${f}, add this to rgx.toml:`)
+console.log(`trusted_keys = ["${publicKey(privateKey).pinned}"]`)
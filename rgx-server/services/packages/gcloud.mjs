@@ -1,6 +1,6 @@
 import log4js from "../../common/util/log.mjs";
 import * as cache from "../../common/util/cache.mjs";
-import { supportedPlatforms, compatibleTimestamp } from "../../common/util/utils.mjs";
+import { supportedPlatforms, compatibleTimestamp, scriptChecksum } from "../../common/util/utils.mjs";
 
 const log = log4js.getLogger("gcloud-service");
 const gcloudUrl = "https://storage.googleapis.com/cloud-sdk-release/";
@@ -85,11 +85,17 @@ const scriptLinks = {
     linux: '/static/assets/install-scripts/gcloud/rgx-setup.sh'
 }
 
+function binaries (opsys) {
+    const ext = opsys === 'windows' ? '.cmd' : ''
+    return ['gcloud', 'gsutil', 'bq'].map(b => `google-cloud-sdk/bin/${b}${ext}`)
+}
+
 function createRecipe (version, pkg, opsys, pkgUrl) {
     const recipe = {
         script: scriptLinks[opsys],
         script_dir: `google-cloud-sdk/gcloudsdk-${version}`,
         package_version: version,
+        binaries: binaries(opsys),
         artifacts: [
             {
                 artifact_type: 'google-cloud-sdk',
@@ -98,8 +104,20 @@ function createRecipe (version, pkg, opsys, pkgUrl) {
                 extract_dir: `google-cloud-sdk/gcloudsdk-${version}`,
                 extract_target: `google-cloud-sdk/gcloudsdk-${version}`,
                 version,
+                // no checksum: Google lists the sha256 of these archives only on its release notes
+                // page, so rgx installs gcloud with --allow-unverified, see the README
                 link: pkgUrl,
             }
+        ],
+        // the script only installs components, rgx writes the rc files
+        steps: [
+            {
+                action: 'write_env',
+                env: opsys === 'windows'
+                    ? { CLOUDSDK_PYTHON: '{{.PackagesDir}}/google-cloud-sdk/google-cloud-sdk-python-{{.Version}}/python.exe' }
+                    : {},
+                path_entries: ['{{.Target}}/google-cloud-sdk/bin']
+            }
         ]
     }
     return recipe
@@ -121,9 +139,12 @@ export async function latestRelease(p) {
 
     const r = packageDetails(url);
 
+    // the script can change with a deploy while the recipe is cached, so its checksum is not
+    const withChecksum = recipe => ({ ...recipe, script_checksum: scriptChecksum(recipe.script) });
+
     const ckey = `s:gcloud:latestrelease:${majorVersion}-${os}-${arch}`;
     const data = await cache.cget(ckey);
-    if (data) { return { ok: true, data }; }
+    if (data) { return { ok: true, data: withChecksum(data) }; }
 
     try {
         const recipe = createRecipe(majorVersion, {
@@ -135,7 +156,7 @@ export async function latestRelease(p) {
         
         await cache.cput(ckey, recipe);
 
-        return { ok: true, data: recipe };
+        return { ok: true, data: withChecksum(recipe) };
     } catch (e) {
         return { ok: false, error: e.message };
     }
@@ -73,17 +73,39 @@ export async function majorVersions () {
     return { ok: true, data: sortedVersions }
 }
 
-const scriptLinks = {
-    windows: '/static/assets/install-scripts/golang/rgx-setup.cmd',
-    macos: '/static/assets/install-scripts/golang/rgx-setup.sh',
-    linux: '/static/assets/install-scripts/golang/rgx-setup.sh',
+// 1.22.3 is in the series 1.22 and 1.22.3, but 1.220 is not in the series 1.22
+function inSeries (version, series) {
+    return version === series || version.startsWith(series + '.')
+}
+
+export async function releases (p) {
+    const { os: providedOS, arch: providedArch } = p
+    const os = providedOS ? mapOS(providedOS) : ''
+    const arch = providedArch ? mapArch(providedArch) : ''
+
+    const ckey = `s:golang:releases:${os}-${arch}`
+    const data = await cache.cget(ckey)
+    if (data) return { ok: true, data }
+
+    const r = await goReleases()
+    if (!r.ok) return r
+
+    const s = new Set()
+    r.releases.filter(x => (!os || x.os === os) && (!arch || x.arch === arch)).forEach(x => s.add(x.version))
+    const sortedVersions = [...s].toSorted((a,b) => strcmp(normalizeVersion(a), normalizeVersion(b)))
+    await cache.cput(ckey, sortedVersions)
+    return { ok: true, data: sortedVersions }
+}
+
+function binaries (opsys) {
+    const ext = opsys === 'windows' ? '.exe' : ''
+    return ['bin/go' + ext, 'bin/gofmt' + ext]
 }
 
 function createRecipe (version, pkg, opsys) {
     const recipe = {
-        script: scriptLinks[opsys],
-        script_dir: 'golang',
         package_version: version,
+        binaries: binaries(opsys),
         artifacts: [
             {
                 artifact_type: 'golang-sdk',
@@ -91,10 +113,24 @@ function createRecipe (version, pkg, opsys) {
                 name: pkg.name,
                 extract_dir: `golang/go-${version}`,
                 extract_target: `golang/go-${version}`,
+                // the archives have a top level go/ directory, and the sources of the test suite are not needed
+                strip_components: 1,
+                exclude: ['test'],
                 version,
                 link: pkg.link,
                 checksum: pkg.checksum,
-                checksum_type: 'sha256'
+                checksum_type: 'sha256',
+                // checked by rgx if rgx.toml pins the Go signing key in [vendor_keys]
+                signature_link: `${pkg.link}.asc`,
+                signature_type: 'pgp'
+            }
+        ],
+        // rgx writes .golang-<major>-rc, and use-golang-<major>.cmd on windows
+        steps: [
+            {
+                action: 'write_env',
+                env: { GOLANG_HOME: '{{.Target}}' },
+                path_entries: ['{{.Target}}/bin']
             }
         ]
     }
@@ -142,7 +178,7 @@ export async function latestRelease (p) {
         const r = await goReleases()
         if(!r.ok) return r
 
-        r.releases.filter(x => x.version.startsWith(majorVersion) && x.os === os && x.arch === arch).forEach(x => s.add(x.version))
+        r.releases.filter(x => inSeries(x.version, majorVersion) && x.os === os && x.arch === arch).forEach(x => s.add(x.version))
         const sortedVersions = [...s].toSorted((a,b) => strcmp(normalizeVersion(a), normalizeVersion(b)))
         const latest = sortedVersions[sortedVersions.length - 1]
 
@@ -10,6 +10,9 @@ echo %lastline%
 
 del temp_output.txt
 
+rem rgx removes what is listed in RGX_SCRIPT_OUTPUTS on uninstall
+>> "%RGX_SCRIPT_OUTPUTS%" echo %RGX_PACKAGES_DIR%\google-cloud-sdk\google-cloud-sdk-python-%RGX_PACKAGE_VERSION%
+
 set CLOUDSDK_PYTHON=%RGX_PACKAGES_DIR%\google-cloud-sdk\google-cloud-sdk-python-%RGX_PACKAGE_VERSION%\python.exe
 set CLOUDSDK_PYTHON %CLOUDSDK_PYTHON%
 
@@ -23,11 +26,4 @@ for /f "delims=" %%i in ('""%GCLOUD_CMD%" components copy-bundled-python"') do (
 
 call %GCLOUD_CMD% components install skaffold kubectl --quiet
 
-echo set CLOUDSDK_PYTHON=%RGX_PACKAGES_DIR%\google-cloud-sdk\google-cloud-sdk-python-%RGX_PACKAGE_VERSION%\python.exe > %RGX_RCFILE_DIR%\use-gcloud-%RGX_PACKAGE_VERSION%.cmd
-echo PATH %RGX_PACKAGE_SCRIPTDIR%\google-cloud-sdk\bin;%PATH% >> %RGX_RCFILE_DIR%\use-gcloud-%RGX_PACKAGE_VERSION%.cmd
-echo To use: source %RGX_RCFILE_DIR%\use-gcloud-%RGX_PACKAGE_VERSION%.cmd
-
-echo export PATH=%RGX_PACKAGE_SCRIPTDIR%/google-cloud-sdk/bin:%PATH% > %RGX_RCFILE_DIR%/use-gcloud-%RGX_PACKAGE_VERSION%.rc
-echo To use: source %RGX_RCFILE_DIR%/use-gcloud-%RGX_PACKAGE_VERSION%.rc
-
 echo Google Cloud SDK setup completed successfully.
\ No newline at end of file
@@ -1,12 +1,9 @@
-#!/bin/bash
+#!/usr/bin/env sh
+set -e
 
 echo "This may take a few minutes. Please wait..."
 
-GCLOUD_CMD="$RGX_PACKAGES_DIR/google-cloud-sdk/gcloudsdk-$RGX_PACKAGE_VERSION/google-cloud-sdk/bin/gcloud"
+GCLOUD_CMD="$RGX_PACKAGE_SCRIPTDIR/google-cloud-sdk/bin/gcloud"
 
 echo "Installing skaffold and kubectl components..."
-$GCLOUD_CMD components install skaffold kubectl --quiet
-
-echo "export PATH=$RGX_PACKAGES_DIR/google-cloud-sdk/bin:\$PATH" > "${RGX_RCFILE_DIR}/.gcloud-$RGX_PACKAGE_MAJORVERSION-rc"
-
-echo "Script completed. RC file created at: ${RGX_RCFILE_DIR}/.gcloud-$RGX_PACKAGE_MAJORVERSION-rc"
\ No newline at end of file
+"$GCLOUD_CMD" components install skaffold kubectl --quiet
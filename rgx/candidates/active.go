@@ -0,0 +1,191 @@
+package candidates
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"rgx/common/log"
+	"rgx/common/utils"
+	"rgx/common/version"
+)
+
+const currentLinkName = "current"
+
+// currentLink is the stable location that always points at the active version of pkg
+func currentLink(config *utils.RgxConfig, pkg string) string {
+	return filepath.Join(config.PackagesDir, pkg, currentLinkName)
+}
+
+// homeDir is where an installed package lives, i.e. the target of its first extract artifact
+func homeDir(p InstalledPackage) string {
+	for _, a := range p.Artifacts {
+		if a.Action == "extract" && a.Target != "" {
+			return a.Target
+		}
+	}
+	return ""
+}
+
+// latestInstall picks the highest version of several matching installs
+func latestInstall(found []InstalledPackage) InstalledPackage {
+	sort.SliceStable(found, func(i, j int) bool {
+		return compareVersions(found[i].PackageVersion, found[j].PackageVersion) < 0
+	})
+	return found[len(found)-1]
+}
+
+// compareVersions orders unparseable versions before parseable ones, and by string among themselves
+func compareVersions(a, b string) int {
+	va, errA := version.Parse(a)
+	vb, errB := version.Parse(b)
+	switch {
+	case errA == nil && errB == nil:
+		return va.Compare(vb)
+	case errA == nil:
+		return 1
+	case errB == nil:
+		return -1
+	}
+	return strings.Compare(a, b)
+}
+
+func Use(config *utils.RgxConfig, pkg, version string) error {
+	reg, e := ReadRegistry(config)
+	if e != nil {
+		return e
+	}
+	found := reg.Find(pkg, version)
+	if len(found) == 0 {
+		return fmt.Errorf("%w: %s %s, run: rgx install %s %s", ErrNotInstalled, pkg, version, pkg, version)
+	}
+	p := latestInstall(found)
+	home := homeDir(p)
+	if home == "" {
+		return fmt.Errorf("%s %s has no installation directory to activate", pkg, p.PackageVersion)
+	}
+
+	link := currentLink(config, pkg)
+	if e = os.MkdirAll(filepath.Dir(link), 0775); e != nil {
+		return e
+	}
+	if e = removeLink(link); e != nil {
+		return e
+	}
+	if utils.PlatformOS() == "windows" {
+		// junctions, unlike symlinks, do not need elevated privileges
+		out, e := exec.Command("cmd", "/c", "mklink", "/J", link, home).CombinedOutput()
+		if e != nil {
+			return fmt.Errorf("could not create junction %s: %s %s", link, e.Error(), string(out))
+		}
+	} else if e = os.Symlink(home, link); e != nil {
+		return fmt.Errorf("could not create symlink %s: %w", link, e)
+	}
+
+	reg.SetActive(pkg, p.PackageVersion)
+	if e = reg.Save(); e != nil {
+		return e
+	}
+	log.Info("now using %s %s (%s)", pkg, p.PackageVersion, home)
+	return nil
+}
+
+func removeLink(link string) error {
+	info, e := os.Lstat(link)
+	if os.IsNotExist(e) {
+		return nil
+	}
+	if e != nil {
+		return e
+	}
+	if info.Mode()&os.ModeSymlink == 0 && !isJunction(info) {
+		return fmt.Errorf("%w: %s exists and is not a link created by rgx, please remove it", ErrUnsafePath, link)
+	}
+	if e = os.Remove(link); e != nil {
+		return fmt.Errorf("could not remove %s: %w", link, e)
+	}
+	return nil
+}
+
+func isJunction(info os.FileInfo) bool {
+	return utils.PlatformOS() == "windows" && info.Mode()&os.ModeIrregular != 0
+}
+
+// deactivate removes the current link of p's package, if p is the active version
+func deactivate(config *utils.RgxConfig, reg *Registry, p InstalledPackage) error {
+	if reg.Active[p.Package] != p.PackageVersion {
+		return nil
+	}
+	if e := removeLink(currentLink(config, p.Package)); e != nil {
+		return e
+	}
+	delete(reg.Active, p.Package)
+	log.Info("%s no longer has an active version", p.Package)
+	return nil
+}
+
+// CurrentVersion is the version of a package in use and what selected it
+type CurrentVersion struct {
+	Package string `json:"package"`
+	// Version is the selected version, empty if none is
+	Version string `json:"version,omitempty"`
+	// Source is the project file that pins Version, or "rgx use"
+	Source string `json:"source,omitempty"`
+	// PackageVersion and Home are the install Version resolves to, empty if it is not installed
+	PackageVersion string `json:"package_version,omitempty"`
+	Home           string `json:"home,omitempty"`
+}
+
+// Current lists the version in use of pkg, or of every package that is active or pinned by
+// the project file if pkg is empty
+func Current(config *utils.RgxConfig, pkg string) ([]CurrentVersion, error) {
+	reg, e := ReadRegistry(config)
+	if e != nil {
+		return nil, e
+	}
+	var pkgs []string
+	if pkg != "" {
+		pkgs = []string{pkg}
+	} else {
+		seen := make(map[string]bool)
+		for k := range reg.Active {
+			seen[k] = true
+		}
+		if fname := FindProjectFile(); fname != "" {
+			pins, e := ReadProjectFile(fname)
+			if e != nil {
+				return nil, e
+			}
+			for _, p := range pins {
+				seen[p.Package] = true
+			}
+		}
+		for k := range seen {
+			pkgs = append(pkgs, k)
+		}
+		sort.Strings(pkgs)
+	}
+
+	var current []CurrentVersion
+	for _, k := range pkgs {
+		c := CurrentVersion{Package: k, Version: reg.Active[k], Source: "rgx use"}
+		pinned, fname, isPinned, e := Pinned(k)
+		if e != nil {
+			return nil, e
+		}
+		if isPinned {
+			c.Version, c.Source = pinned, fname
+		}
+		if c.Version == "" {
+			c.Source = ""
+		} else if found := reg.Find(k, c.Version); len(found) > 0 {
+			p := latestInstall(found)
+			c.PackageVersion, c.Home = p.PackageVersion, homeDir(p)
+		}
+		current = append(current, c)
+	}
+	return current, nil
+}
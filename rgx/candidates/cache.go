@@ -0,0 +1,330 @@
+package candidates
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"rgx/common/http"
+	"rgx/common/log"
+	"rgx/common/utils"
+)
+
+// The download cache keeps artifacts in the download directory under the checksum they are
+// published with, cache/<algorithm>/<hash>, so that files with the same name don't collide and
+// packages share files with the same contents. Artifacts published without a checksum are
+// kept under cache/url/<sha256 of the link>. Next to every file, <file>.json records where it
+// came from; the modification time of the file is when an install last used it.
+const (
+	cacheDirName = "cache"
+	urlKeys      = "url"
+	metaSuffix   = ".json"
+)
+
+var validHash = regexp.MustCompile(`^[0-9a-f]+$`)
+
+type CacheEntry struct {
+	// Key is <algorithm>/<hash>, or url/<hash> for files published without a checksum
+	Key      string    `json:"key"`
+	Path     string    `json:"path"`
+	Name     string    `json:"name"`
+	Link     string    `json:"link"`
+	Size     int64     `json:"size"`
+	LastUsed time.Time `json:"last_used"`
+	// Packages are the installed packages that were installed from the file
+	Packages []string `json:"packages,omitempty"`
+}
+
+type cacheMeta struct {
+	Name  string    `json:"name"`
+	Link  string    `json:"link"`
+	Added time.Time `json:"added"`
+}
+
+func cacheDir(config *utils.RgxConfig) string {
+	return filepath.Join(config.DownloadDir, cacheDirName)
+}
+
+// cacheKey names the file of an artifact in the cache after its first checksum
+func cacheKey(link string, sums []utils.Checksum) string {
+	if len(sums) == 0 {
+		sum := sha256.Sum256([]byte(link))
+		return urlKeys + "/" + hex.EncodeToString(sum[:])
+	}
+	return sums[0].Algorithm + "/" + sums[0].Hash
+}
+
+func cachePath(config *utils.RgxConfig, key string) string {
+	return filepath.Join(cacheDir(config), filepath.FromSlash(key))
+}
+
+// fetchCached returns the cached file for a, downloading it if it is not in the cache or
+// no longer matches its checksum
+func fetchCached(ctx context.Context, config *utils.RgxConfig, a artifact) (string, error) {
+	sums, e := a.checksums()
+	if e != nil {
+		return "", fmt.Errorf("%s: %w", a.Name, e)
+	}
+	if len(sums) == 0 {
+		if !config.AllowUnverified {
+			return "", fmt.Errorf("%w: %w: the server publishes no checksum for %s, use --allow-unverified to install it anyway",
+				utils.ErrChecksumMismatch, utils.ErrUnverified, a.Name)
+		}
+		log.Warn("installing %s without verifying it, the server publishes no checksum for it", a.Name)
+	}
+	target := cachePath(config, cacheKey(a.Link, sums))
+
+	if utils.Exists(target) {
+		ok, e := matches(target, sums)
+		if e != nil {
+			return "", fmt.Errorf("could not verify checksum of %s: %w", target, e)
+		}
+		if ok {
+			log.Debug("already in the cache, not downloading again: %s", target)
+			touch(target)
+			return target, nil
+		}
+		log.Warn("%s in the cache does not match its checksum, downloading it again", a.Name)
+		if e := removeCached(target); e != nil {
+			return "", e
+		}
+	} else if adoptDownload(config, a, sums, target) {
+		return target, nil
+	}
+
+	if e := os.MkdirAll(filepath.Dir(target), 0775); e != nil {
+		return "", e
+	}
+	log.Trace("downloading %s", a.Link)
+	if e := http.Download(ctx, config, a.Link, target, sums); e != nil {
+		if errors.Is(e, utils.ErrChecksumMismatch) {
+			_ = removeCached(target)
+		}
+		return "", fmt.Errorf("failed to download %s: %w", a.Link, e)
+	}
+	writeMeta(target, a)
+	return target, nil
+}
+
+// matches reports whether path has all the checksums in sums
+func matches(path string, sums []utils.Checksum) (bool, error) {
+	e := utils.Verify(path, sums)
+	if errors.Is(e, utils.ErrChecksumMismatch) {
+		return false, nil
+	}
+	return e == nil, e
+}
+
+// adoptDownload moves a file that an older rgx downloaded to <download_dir>/<name> into the
+// cache, if it has the checksum a expects
+func adoptDownload(config *utils.RgxConfig, a artifact, sums []utils.Checksum, target string) bool {
+	old := filepath.Join(config.DownloadDir, a.Name)
+	if len(sums) == 0 || a.Name == "" || !utils.Exists(old) {
+		return false
+	}
+	if ok, e := matches(old, sums); e != nil || !ok {
+		return false
+	}
+	if e := os.MkdirAll(filepath.Dir(target), 0775); e != nil {
+		return false
+	}
+	if e := os.Rename(old, target); e != nil {
+		log.Debug("could not move %s into the cache: %s", old, e.Error())
+		return false
+	}
+	log.Debug("moved %s into the cache", old)
+	writeMeta(target, a)
+	return true
+}
+
+func touch(path string) {
+	now := time.Now()
+	if e := os.Chtimes(path, now, now); e != nil {
+		log.Debug("could not update the modification time of %s: %s", path, e.Error())
+	}
+}
+
+func writeMeta(path string, a artifact) {
+	b, _ := json.MarshalIndent(cacheMeta{Name: a.Name, Link: a.Link, Added: time.Now().UTC()}, "", "  ")
+	if e := os.WriteFile(path+metaSuffix, b, 0664); e != nil {
+		log.Debug("could not write %s: %s", path+metaSuffix, e.Error())
+	}
+}
+
+// CacheEntries lists the files in the download cache, most recently used first
+func CacheEntries(config *utils.RgxConfig) ([]CacheEntry, error) {
+	reg, e := ReadRegistry(config)
+	if e != nil {
+		return nil, e
+	}
+	users := make(map[string][]string)
+	for _, p := range reg.Sorted() {
+		for _, a := range p.Artifacts {
+			if sums, e := parseChecksums(a.Checksum, a.ChecksumType, a.Checksums); e == nil {
+				key := cacheKey(a.Link, sums)
+				users[key] = append(users[key], p.Package+" "+p.PackageVersion)
+			}
+		}
+	}
+
+	var entries []CacheEntry
+	algorithms, e := os.ReadDir(cacheDir(config))
+	if os.IsNotExist(e) {
+		return nil, nil
+	}
+	if e != nil {
+		return nil, e
+	}
+	for _, algorithm := range algorithms {
+		if !algorithm.IsDir() {
+			continue
+		}
+		files, e := os.ReadDir(filepath.Join(cacheDir(config), algorithm.Name()))
+		if e != nil {
+			return nil, e
+		}
+		for _, f := range files {
+			if f.IsDir() || !validHash.MatchString(f.Name()) {
+				// metadata and partial downloads
+				continue
+			}
+			info, e := f.Info()
+			if e != nil {
+				continue
+			}
+			key := algorithm.Name() + "/" + f.Name()
+			path := cachePath(config, key)
+			var meta cacheMeta
+			if b, e := os.ReadFile(path + metaSuffix); e == nil {
+				_ = json.Unmarshal(b, &meta)
+			}
+			entries = append(entries, CacheEntry{
+				Key:      key,
+				Path:     path,
+				Name:     meta.Name,
+				Link:     meta.Link,
+				Size:     info.Size(),
+				LastUsed: info.ModTime(),
+				Packages: users[key],
+			})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].LastUsed.After(entries[j].LastUsed)
+	})
+	return entries, nil
+}
+
+// CacheSize returns the number of files in the download cache and their total size,
+// including partial downloads
+func CacheSize(config *utils.RgxConfig) (int, int64, error) {
+	var files int
+	var size int64
+	e := filepath.WalkDir(cacheDir(config), func(path string, d os.DirEntry, e error) error {
+		if os.IsNotExist(e) {
+			return nil
+		}
+		if e != nil || d.IsDir() || strings.HasSuffix(path, metaSuffix) || strings.HasSuffix(path, ".validator") {
+			return e
+		}
+		info, e := d.Info()
+		if e != nil {
+			return e
+		}
+		files++
+		size += info.Size()
+		return nil
+	})
+	return files, size, e
+}
+
+// CleanCache removes the files from the download cache that were last used more than
+// olderThan ago, or all of them if olderThan is 0. With keepInstalled, files that installed
+// packages were installed from are kept. Partial downloads are removed with the same rules.
+func CleanCache(config *utils.RgxConfig, olderThan time.Duration, keepInstalled bool) ([]CacheEntry, error) {
+	entries, e := CacheEntries(config)
+	if e != nil {
+		return nil, e
+	}
+	cutoff := time.Now().Add(-olderThan)
+	var removed []CacheEntry
+	for _, entry := range entries {
+		if (olderThan > 0 && entry.LastUsed.After(cutoff)) || (keepInstalled && len(entry.Packages) > 0) {
+			continue
+		}
+		log.Debug("removing %s from the cache", entry.Path)
+		if e := removeCached(entry.Path); e != nil {
+			return removed, e
+		}
+		removed = append(removed, entry)
+	}
+
+	partials, _ := filepath.Glob(filepath.Join(cacheDir(config), "*", "*.rgxdownload"))
+	for _, partial := range partials {
+		info, e := os.Stat(partial)
+		if e != nil || (olderThan > 0 && info.ModTime().After(cutoff)) {
+			continue
+		}
+		log.Debug("removing the partial download %s", partial)
+		if e := removeCached(partial); e != nil {
+			return removed, e
+		}
+		removed = append(removed, CacheEntry{Path: partial, Size: info.Size(), LastUsed: info.ModTime()})
+	}
+	return removed, nil
+}
+
+// removeCached removes a file in the cache together with what is kept next to it
+func removeCached(path string) error {
+	for _, f := range []string{path, path + metaSuffix, path + ".rgxdownload.validator"} {
+		if e := os.Remove(f); e != nil && !os.IsNotExist(e) {
+			return fmt.Errorf("could not remove %s: %w", f, e)
+		}
+	}
+	return nil
+}
+
+// VerifyCache checks every file in the download cache against the checksum it is stored
+// under, and removes those that don't match so that the next install downloads them again.
+// Files published without a checksum can't be verified and are skipped.
+func VerifyCache(ctx context.Context, config *utils.RgxConfig) (verified int, corrupt []CacheEntry, err error) {
+	entries, e := CacheEntries(config)
+	if e != nil {
+		return 0, nil, e
+	}
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return verified, corrupt, ctx.Err()
+		}
+		algorithm, hash, _ := strings.Cut(entry.Key, "/")
+		if algorithm == urlKeys {
+			continue
+		}
+		ok, e := matches(entry.Path, []utils.Checksum{{Algorithm: algorithm, Hash: hash}})
+		if e != nil {
+			return verified, corrupt, fmt.Errorf("could not verify %s: %w", entry.Path, e)
+		}
+		verified++
+		if ok {
+			continue
+		}
+		log.Warn("%s (%s) does not match its checksum, removing it", entry.Key, entry.Name)
+		if e := removeCached(entry.Path); e != nil {
+			return verified, corrupt, e
+		}
+		corrupt = append(corrupt, entry)
+	}
+	if len(corrupt) > 0 {
+		return verified, corrupt, fmt.Errorf("%w: %d of %d files in the download cache", utils.ErrChecksumMismatch, len(corrupt), verified)
+	}
+	return verified, corrupt, nil
+}
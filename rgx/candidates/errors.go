@@ -0,0 +1,15 @@
+package candidates
+
+import "errors"
+
+// Errors returned by this package are wrapped around one of these, so callers can tell
+// failures apart with errors.Is
+var (
+	ErrPackageNotFound   = errors.New("package not found")
+	ErrNoMatchingVersion = errors.New("no matching version")
+	ErrNotInstalled      = errors.New("not installed")
+	ErrRegistry          = errors.New("install registry error")
+	ErrProjectFile       = errors.New("project file error")
+	ErrLockMismatch      = errors.New("lock file mismatch")
+	ErrUnsafePath        = errors.New("unsafe path")
+)
@@ -0,0 +1,87 @@
+package candidates
+
+import (
+	"context"
+	"sync"
+
+	"rgx/common/utils"
+)
+
+// fetcher downloads the artifacts of a recipe in the background, at most
+// max_parallel_downloads at a time, so that they can be installed in order as they arrive
+type fetcher struct {
+	results []*fetchResult
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+type fetchResult struct {
+	done chan struct{}
+	path string
+	err  error
+}
+
+func startFetching(ctx context.Context, config *utils.RgxConfig, pkg string, artifacts []artifact) *fetcher {
+	ctx, cancel := context.WithCancel(ctx)
+	f := &fetcher{results: make([]*fetchResult, len(artifacts)), cancel: cancel}
+
+	// artifacts that share a file in the cache are downloaded once, but each is checked
+	// against its own signature
+	var todo [][]int
+	byTarget := make(map[string]int)
+	for i, a := range artifacts {
+		f.results[i] = &fetchResult{done: make(chan struct{})}
+		target := a.Link
+		if sums, e := a.checksums(); e == nil {
+			target = cacheKey(a.Link, sums)
+		}
+		if n, ok := byTarget[target]; ok {
+			todo[n] = append(todo[n], i)
+			continue
+		}
+		byTarget[target] = len(todo)
+		todo = append(todo, []int{i})
+	}
+
+	slots := make(chan struct{}, max(1, config.MaxParallelDownloads))
+	f.wg.Add(len(todo))
+	go func() {
+		// downloads start in recipe order, so that the first artifact is ready first
+		for _, same := range todo {
+			slots <- struct{}{}
+			go func(same []int) {
+				defer f.wg.Done()
+				defer func() { <-slots }()
+				path, err := "", ctx.Err()
+				if err == nil {
+					path, err = fetchCached(ctx, config, artifacts[same[0]])
+				}
+				for _, i := range same {
+					res := f.results[i]
+					res.path, res.err = path, err
+					if res.err == nil {
+						res.err = verifyVendorSignature(ctx, config, pkg, artifacts[i], path)
+					}
+					close(res.done)
+				}
+			}(same)
+		}
+	}()
+	return f
+}
+
+// wait blocks until artifact i is in the download cache and returns its path there
+func (f *fetcher) wait(ctx context.Context, i int) (string, error) {
+	select {
+	case <-f.results[i].done:
+		return f.results[i].path, f.results[i].err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// stop cancels the downloads still running and waits for them to clean up
+func (f *fetcher) stop() {
+	f.cancel()
+	f.wg.Wait()
+}
@@ -0,0 +1,40 @@
+package candidates
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"rgx/common/utils"
+)
+
+func TestFetchingChecksEverySignatureOfASharedFile(t *testing.T) {
+	content := []byte("the artifact")
+	key, other := newMinisignKey(t, "12345678"), newMinisignKey(t, "12345678")
+	srv := fileServer(t, map[string][]byte{
+		"/demo.tar.gz":        content,
+		"/demo.tar.gz.good":   key.sign(content, true),
+		"/demo.tar.gz.forged": other.sign(content, true),
+	})
+	sum := sha256.Sum256(content)
+	a := artifact{Name: "demo.tar.gz", Link: srv.URL + "/demo.tar.gz", Checksum: hex.EncodeToString(sum[:]),
+		ChecksumType: "sha256", SignatureType: signatureMinisign, SignatureLink: srv.URL + "/demo.tar.gz.good"}
+	forged := a
+	forged.SignatureLink = srv.URL + "/demo.tar.gz.forged"
+
+	f := startFetching(context.Background(), vendorConfig(t, key.publicKey()), "demo", []artifact{a, forged})
+	defer f.stop()
+	first, e := f.wait(context.Background(), 0)
+	if e != nil {
+		t.Fatal(e)
+	}
+	second, e := f.wait(context.Background(), 1)
+	if !errors.Is(e, utils.ErrSignature) {
+		t.Errorf("the artifact with a forged signature returned %v, want ErrSignature", e)
+	}
+	if second != first {
+		t.Errorf("the shared file was downloaded to %s and %s", first, second)
+	}
+}
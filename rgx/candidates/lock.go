@@ -0,0 +1,180 @@
+package candidates
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"rgx/common/log"
+	"rgx/common/utils"
+)
+
+// lockFileName is written next to the project file that lists the requested packages
+const lockFileName = "rgx.lock"
+
+// lockPlatforms are all the os/arch combinations a lock file resolves recipes for, so that
+// one lock file serves every developer and CI machine
+var lockPlatforms = []string{
+	"linux/x64", "linux/arm64",
+	"macos/x64", "macos/arm64",
+	"windows/x64", "windows/arm64",
+}
+
+type LockedPackage struct {
+	Package      string `json:"package"`
+	Requested    string `json:"requested"`
+	MajorVersion string `json:"major_version"`
+	// Release is what the server is asked for to get the locked recipe, the exact release
+	// where the server lists every release and the major version otherwise
+	Release string `json:"release"`
+	// Platforms maps "<os>/<arch>" to the exact recipe the server returned when locking
+	Platforms map[string]recipe `json:"platforms"`
+}
+
+type LockFile struct {
+	Packages []LockedPackage `json:"packages"`
+}
+
+func lockFilePath(projectFile string) string {
+	return filepath.Join(filepath.Dir(projectFile), lockFileName)
+}
+
+func currentPlatform() string {
+	return utils.PlatformOS() + "/" + utils.PlatformArch()
+}
+
+// Lock resolves every package in the project file to exact recipes and writes the lock file
+func Lock(ctx context.Context, config *utils.RgxConfig, lts bool) error {
+	fname, e := requireProjectFile()
+	if e != nil {
+		return e
+	}
+	pins, e := ReadProjectFile(fname)
+	if e != nil {
+		return e
+	}
+
+	var lock LockFile
+	for _, p := range pins {
+		majorVersion, release, _, e := resolveRecipe(ctx, config, p.Package, p.Version, lts)
+		if e != nil {
+			return e
+		}
+		locked := LockedPackage{
+			Package:      p.Package,
+			Requested:    p.Version,
+			MajorVersion: majorVersion,
+			Release:      release,
+			Platforms:    make(map[string]recipe),
+		}
+		for _, platform := range lockPlatforms {
+			opsys, arch, _ := strings.Cut(platform, "/")
+			r, found, e := fetchRecipe(ctx, config, p.Package, release, opsys, arch)
+			if e != nil {
+				return fmt.Errorf("could not lock %s %s for %s: %w", p.Package, release, platform, e)
+			}
+			if !found {
+				log.Debug("%s %s is not available for %s", p.Package, release, platform)
+				continue
+			}
+			locked.Platforms[platform] = r
+		}
+		if len(locked.Platforms) == 0 {
+			return fmt.Errorf("%w: %s %s is not available for any platform", ErrPackageNotFound, p.Package, release)
+		}
+		if r, ok := locked.Platforms[currentPlatform()]; ok {
+			log.Info("locked %s %s -> %s", p.Package, p.Version, r.PackageVersion)
+		} else {
+			log.Info("locked %s %s (not available for %s)", p.Package, p.Version, currentPlatform())
+		}
+		lock.Packages = append(lock.Packages, locked)
+	}
+
+	b, e := json.MarshalIndent(lock, "", "  ")
+	if e != nil {
+		return e
+	}
+	lockFile := lockFilePath(fname)
+	if e = os.WriteFile(lockFile, append(b, '\n'), 0664); e != nil {
+		return fmt.Errorf("could not write %s: %w", lockFile, e)
+	}
+	log.Info("wrote %s", lockFile)
+	return nil
+}
+
+func ReadLockFile(fname string) (LockFile, error) {
+	var lock LockFile
+	b, e := os.ReadFile(fname)
+	if e != nil {
+		return lock, fmt.Errorf("%w: could not read %s: %s, run: rgx lock", ErrLockMismatch, fname, e.Error())
+	}
+	if e = json.Unmarshal(b, &lock); e != nil {
+		return lock, fmt.Errorf("%w: could not parse %s: %s", ErrLockMismatch, fname, e.Error())
+	}
+	return lock, nil
+}
+
+// InstallFrozen installs exactly what the lock file records for this platform, and refuses
+// to install anything if the server would now return a different recipe. It returns the
+// installed packages.
+func InstallFrozen(ctx context.Context, config *utils.RgxConfig) ([]InstalledPackage, error) {
+	fname, e := requireProjectFile()
+	if e != nil {
+		return nil, e
+	}
+	lockFile := lockFilePath(fname)
+	lock, e := ReadLockFile(lockFile)
+	if e != nil {
+		return nil, e
+	}
+
+	pins, e := ReadProjectFile(fname)
+	if e != nil {
+		return nil, e
+	}
+	for _, p := range pins {
+		if findLocked(lock, p.Package, p.Version) == nil {
+			return nil, fmt.Errorf("%w: %s %s is not in %s, run: rgx lock", ErrLockMismatch, p.Package, p.Version, lockFile)
+		}
+	}
+
+	platform := currentPlatform()
+	recipes := make([]recipe, len(lock.Packages))
+	for i, locked := range lock.Packages {
+		r, ok := locked.Platforms[platform]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s has no entry for %s %s on %s", ErrLockMismatch, lockFile, locked.Package, locked.MajorVersion, platform)
+		}
+		current, e := DownloadRecipe(ctx, config, locked.Package, locked.Release)
+		if e != nil {
+			return nil, e
+		}
+		if diff := recipeDiff(r, current); diff != "" {
+			return nil, fmt.Errorf("%w: the server's recipe for %s %s differs from %s: %s", ErrLockMismatch, locked.Package, locked.Release, lockFile, diff)
+		}
+		recipes[i] = r
+	}
+
+	var installed []InstalledPackage
+	for i, locked := range lock.Packages {
+		log.Info("installing %s %s from %s", locked.Package, recipes[i].PackageVersion, lockFile)
+		p, e := installRecipe(ctx, config, locked.Package, locked.MajorVersion, recipes[i])
+		if e != nil {
+			return installed, e
+		}
+		installed = append(installed, p)
+	}
+	return installed, nil
+}
+
+func findLocked(lock LockFile, pkg, requested string) *LockedPackage {
+	for i, p := range lock.Packages {
+		if p.Package == pkg && p.Requested == requested {
+			return &lock.Packages[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package candidates
+
+import (
+	"context"
+	"fmt"
+
+	"rgx/common/log"
+	"rgx/common/utils"
+)
+
+// OutdatedPackage is an install that has a newer release of its major version on the server
+type OutdatedPackage struct {
+	Installed InstalledPackage `json:"installed"`
+	// Latest is the version the server would install today
+	Latest string `json:"latest"`
+	recipe recipe
+}
+
+// Outdated compares the newest installed version of each package and major version, of pkg or
+// of every package if pkg is empty, with the version the server would install today
+func Outdated(ctx context.Context, config *utils.RgxConfig, pkg string) ([]OutdatedPackage, error) {
+	reg, e := ReadRegistry(config)
+	if e != nil {
+		return nil, e
+	}
+	newest := make(map[string]InstalledPackage)
+	var keys []string
+	for _, p := range reg.Sorted() {
+		if pkg != "" && p.Package != pkg {
+			continue
+		}
+		k := p.Package + " " + p.MajorVersion
+		if _, ok := newest[k]; !ok {
+			keys = append(keys, k)
+		}
+		newest[k] = p // Sorted() orders by version, so the last one wins
+	}
+	if pkg != "" && len(keys) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNotInstalled, pkg)
+	}
+
+	var outdated []OutdatedPackage
+	for _, k := range keys {
+		p := newest[k]
+		r, found, e := fetchRecipe(ctx, config, p.Package, p.MajorVersion, utils.PlatformOS(), utils.PlatformArch())
+		if e != nil {
+			return nil, fmt.Errorf("could not check %s %s: %w", p.Package, p.MajorVersion, e)
+		}
+		if !found {
+			log.Warn("%s %s is no longer available on the server", p.Package, p.MajorVersion)
+			continue
+		}
+		if compareVersions(r.PackageVersion, p.PackageVersion) > 0 {
+			outdated = append(outdated, OutdatedPackage{Installed: p, Latest: r.PackageVersion, recipe: r})
+		}
+	}
+	return outdated, nil
+}
+
+// Upgrade installs the newest patch release of each outdated package, and with prune removes
+// the release it replaces. It returns the packages it upgraded.
+func Upgrade(ctx context.Context, config *utils.RgxConfig, pkg string, prune bool) ([]OutdatedPackage, error) {
+	outdated, e := Outdated(ctx, config, pkg)
+	if e != nil {
+		return nil, e
+	}
+	for i, o := range outdated {
+		old := o.Installed
+		log.Info("upgrading %s %s -> %s", old.Package, old.PackageVersion, o.Latest)
+		if _, e = installRecipe(ctx, config, old.Package, old.MajorVersion, o.recipe); e != nil {
+			return outdated[:i], e
+		}
+		reg, e := ReadRegistry(config)
+		if e != nil {
+			return outdated[:i], e
+		}
+		if reg.Active[old.Package] == old.PackageVersion {
+			if e = Use(config, old.Package, o.Latest); e != nil {
+				return outdated[:i], e
+			}
+		}
+		if prune {
+			if _, e = Uninstall(config, old.Package, old.PackageVersion, false); e != nil {
+				return outdated[:i], e
+			}
+		}
+	}
+	return outdated, nil
+}
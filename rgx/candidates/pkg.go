@@ -1,60 +1,74 @@
 package candidates
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"rgx/common/http"
 	"rgx/common/log"
 	"rgx/common/utils"
 )
 
-type packagesResponse struct {
-	Name        string
-	Description string
+type ServerPackage struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
 }
 
-func PrintServerPackages() {
-	resp, e := http.GetText(utils.Config.ServerUrl + "/packages")
+func ServerPackages(ctx context.Context, config *utils.RgxConfig) ([]ServerPackage, error) {
+	resp, e := http.GetText(ctx, config, config.ServerUrl+"/packages")
 	if e != nil {
-		log.Fatal("could not connect to rgx server: %s", e.Error())
+		return nil, fmt.Errorf("could not connect to rgx server: %w", e)
 	}
-	var pkgs []packagesResponse
+	var pkgs []ServerPackage
 	err := json.Unmarshal([]byte(resp.Text), &pkgs)
 	if err != nil {
-		log.Fatal("could not parse server json: " + err.Error())
+		return nil, fmt.Errorf("%w: could not parse server json: %s", http.ErrBadResponse, err.Error())
 	}
+	return pkgs, nil
+}
 
-	for _, r := range pkgs {
-		fmt.Printf("%s - %s\n", r.Name, r.Description)
+func MajorVersions(ctx context.Context, config *utils.RgxConfig, pkg string, ltsOnly bool) ([]string, error) {
+	var u = "/packages/" + pkg + "/versions"
+	if ltsOnly {
+		u += "?lts=1"
+	}
+	resp, e := http.GetText(ctx, config, config.ServerUrl+u)
+	if e != nil {
+		if errors.Is(e, http.ErrNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrPackageNotFound, pkg)
+		}
+		return nil, e
 	}
-}
 
-func PrintMajorVersions(pkg string, ltsOnly bool) {
-	var versions = MajorVersions(pkg, ltsOnly)
-	for _, r := range versions {
-		fmt.Printf("%s ", r)
+	var releases []string
+	err := json.Unmarshal([]byte(resp.Text), &releases)
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not parse server json: %s", http.ErrBadResponse, err.Error())
 	}
-	fmt.Println()
+	return releases, nil
 }
 
-func MajorVersions(pkg string, ltsOnly bool) []string {
-	var u = "/packages/" + pkg + "/versions"
+// Releases lists every release of pkg available for this platform, oldest first. It returns
+// false if the server can only list major versions for this package.
+func Releases(ctx context.Context, config *utils.RgxConfig, pkg string, ltsOnly bool) ([]string, bool, error) {
+	var u = "/packages/" + pkg + "/releases?os=" + utils.PlatformOS() + "&arch=" + utils.PlatformArch()
 	if ltsOnly {
-		u += "?lts=1"
+		u += "&lts=1"
 	}
-	resp, e := http.GetText(utils.Config.ServerUrl + u)
+	resp, e := http.GetText(ctx, config, config.ServerUrl+u)
 	if e != nil {
-		if resp.ResponseCode == 404 {
-			log.Fatal("package not found: %s", pkg)
-		} else {
-			log.Fatal("invalid server response: " + e.Error())
+		if resp.ResponseCode == 404 || resp.ResponseCode == 400 {
+			log.Debug("server does not list releases of %s: %s", pkg, e.Error())
+			return nil, false, nil
 		}
+		return nil, false, e
 	}
 
 	var releases []string
 	err := json.Unmarshal([]byte(resp.Text), &releases)
 	if err != nil {
-		log.Fatal("could not parse server json: " + err.Error())
+		return nil, false, fmt.Errorf("%w: could not parse server json: %s", http.ErrBadResponse, err.Error())
 	}
-	return releases
+	return releases, true, nil
 }
@@ -0,0 +1,131 @@
+package candidates
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"rgx/common/log"
+	"rgx/common/utils"
+)
+
+// projectFile pins package versions for a directory tree, one "<package> <version>" per line
+const projectFile = ".rgx-versions"
+
+type PinnedVersion struct {
+	Package string
+	Version string
+}
+
+// FindProjectFile looks for a project file in the current directory and its parents
+func FindProjectFile() string {
+	dir, e := os.Getwd()
+	if e != nil {
+		log.Debug("could not get the current directory: %s", e.Error())
+		return ""
+	}
+	for {
+		f := filepath.Join(dir, projectFile)
+		if utils.Exists(f) {
+			return f
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+func ReadProjectFile(fname string) ([]PinnedVersion, error) {
+	f, e := os.Open(fname)
+	if e != nil {
+		return nil, fmt.Errorf("%w: could not open %s: %s", ErrProjectFile, fname, e.Error())
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			log.Error("could not close %s: %s", fname, err.Error())
+		}
+	}(f)
+
+	var pins []PinnedVersion
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%w: %s:%d: expected '<package> <version>'", ErrProjectFile, fname, lineNo)
+		}
+		pins = append(pins, PinnedVersion{Package: fields[0], Version: fields[1]})
+	}
+	if e := scanner.Err(); e != nil {
+		return nil, fmt.Errorf("%w: could not read %s: %s", ErrProjectFile, fname, e.Error())
+	}
+	return pins, nil
+}
+
+// Pinned returns the version of pkg that the nearest project file asks for, and that file
+func Pinned(pkg string) (string, string, bool, error) {
+	fname := FindProjectFile()
+	if fname == "" {
+		return "", "", false, nil
+	}
+	pins, e := ReadProjectFile(fname)
+	if e != nil {
+		return "", "", false, e
+	}
+	for _, p := range pins {
+		if p.Package == pkg {
+			return p.Version, fname, true, nil
+		}
+	}
+	return "", "", false, nil
+}
+
+// InstallProject installs every package listed in the nearest project file and returns the
+// installed packages
+func InstallProject(ctx context.Context, config *utils.RgxConfig, lts bool) ([]InstalledPackage, error) {
+	fname, e := requireProjectFile()
+	if e != nil {
+		return nil, e
+	}
+	pins, e := ReadProjectFile(fname)
+	if e != nil {
+		return nil, e
+	}
+	if len(pins) == 0 {
+		log.Warn("%s does not list any packages", fname)
+		return nil, nil
+	}
+	log.Info("installing packages listed in %s", fname)
+	var installed []InstalledPackage
+	for _, p := range pins {
+		log.Info("installing %s %s", p.Package, p.Version)
+		x, e := Install(ctx, config, p.Package, p.Version, lts)
+		if e != nil {
+			return installed, e
+		}
+		installed = append(installed, x)
+	}
+	return installed, nil
+}
+
+func requireProjectFile() (string, error) {
+	fname := FindProjectFile()
+	if fname == "" {
+		return "", fmt.Errorf("%w: no %s file found in the current directory or its parents", ErrProjectFile, projectFile)
+	}
+	return fname, nil
+}
@@ -1,126 +1,262 @@
 package candidates
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
 	"strings"
+	"time"
 
 	"rgx/common/http"
 	"rgx/common/log"
 	"rgx/common/utils"
 )
 
-func Install(pkg, suppliedMajorVersion string, lts bool) {
-	var majorVersion string
-	var e error
+// Resolution is what a requested version of a package resolves to on the server
+type Resolution struct {
+	Package      string `json:"package"`
+	Requested    string `json:"requested"`
+	MajorVersion string `json:"major_version"`
+	// Release is what the server is asked for to get the recipe
+	Release        string `json:"release"`
+	PackageVersion string `json:"package_version"`
+}
+
+func Resolve(ctx context.Context, config *utils.RgxConfig, pkg, suppliedVersion string, lts bool) (Resolution, error) {
+	majorVersion, release, r, e := resolveRecipe(ctx, config, pkg, suppliedVersion, lts)
+	if e != nil {
+		return Resolution{}, e
+	}
+	return Resolution{pkg, suppliedVersion, majorVersion, release, r.PackageVersion}, nil
+}
+
+func Install(ctx context.Context, config *utils.RgxConfig, pkg, suppliedVersion string, lts bool) (InstalledPackage, error) {
+	majorVersion, _, r, e := resolveRecipe(ctx, config, pkg, suppliedVersion, lts)
+	if e != nil {
+		return InstalledPackage{}, e
+	}
+	return installRecipe(ctx, config, pkg, majorVersion, r)
+}
+
+func installRecipe(ctx context.Context, config *utils.RgxConfig, pkg, majorVersion string, r recipe) (_ InstalledPackage, err error) {
 	var cleanDirs []string
 	defer utils.CleanDirs(func() []string {
 		return cleanDirs
 	})
+	// directories this install extracted
+	var extracted []string
+	// leftover are install locations an install that did not finish left behind, they are
+	// replaced once this one succeeded
+	var leftover []string
+
+	var installed []InstalledArtifact
+	var copied []string
 
-	if suppliedMajorVersion == "latest" {
-		var versions = MajorVersions(pkg, lts)
-		if len(versions) == 0 {
-			log.Fatal("no versions found for package: %s", pkg)
+	if r.Script != "" && r.ScriptDir != "" {
+		// fail before downloading anything if the script could not run
+		if _, e := r.scriptChecksums(config, pkg); e != nil {
+			return InstalledPackage{}, e
 		}
-		majorVersion = versions[len(versions)-1]
-	} else {
-		majorVersion = suppliedMajorVersion
-		utils.ErrCheck(e)
 	}
-	r := DownloadRecipe(pkg, majorVersion)
+	reg, e := ReadRegistry(config)
+	if e != nil {
+		return InstalledPackage{}, e
+	}
+	stage, e := newStaging(config)
+	if e != nil {
+		return InstalledPackage{}, fmt.Errorf("could not create a staging directory: %w", e)
+	}
+	defer stage.remove()
 
-	for _, a := range r.Artifacts {
+	downloads := startFetching(ctx, config, pkg, r.Artifacts)
+	defer downloads.stop()
 
-		target := filepath.Join(utils.Config.DownloadDir, a.Name)
-		exists := utils.Exists(target)
-		var sumOk = false
-		if exists && a.Checksum != "" {
-			sum, e := utils.Hash(target, a.ChecksumType)
-			if e != nil {
-				log.Fatal("could not verify checksum of %s", target)
-			}
-			sumOk = sum.Hash == a.Checksum
-		} else {
-			sumOk = true
-		}
-		if !sumOk {
-			err := os.Remove(target)
-			if err != nil {
-				log.Fatal("could not remove previously downloaded file: %s", target)
-			}
-		}
-
-		if !utils.Exists(target) {
-			cs := utils.Checksum{Algorithm: a.ChecksumType, Hash: a.Checksum}
-			log.Trace("downloading %s", a.Link)
-			err, ok := http.Download(a.Link, target, cs)
-			if !ok {
-				if err != nil {
-					log.Fatal("failed to download %s: %s", a.Link, err.Error())
-				} else {
-					log.Fatal("failed to download %s", a.Link)
-				}
-			}
-		} else {
-			log.Debug("already exists, not downloading again: %s", target)
+	for i, a := range r.Artifacts {
+		target, e := downloads.wait(ctx, i)
+		if e != nil {
+			return InstalledPackage{}, e
 		}
 
+		var installedTarget string
 		switch a.Action {
 		case "extract":
-			extractdir := filepath.Join(utils.Config.PackagesDir, normalizedPath(a.ExtractDir))
-			targetdir := filepath.Join(utils.Config.PackagesDir, normalizedPath(a.ExtractTarget))
-			if !utils.Exists(targetdir) {
-				e = os.MkdirAll(extractdir, 0775)
-				log.Info("extracting files to %s", extractdir)
-				utils.Extract(target, extractdir)
-				log.Debug("extracted to %s", extractdir)
-				if !utils.Exists(targetdir) {
-					log.Info("finished extracting to %s", targetdir)
+			extractdir := filepath.Join(config.PackagesDir, normalizedPath(a.ExtractDir))
+			targetdir := filepath.Join(config.PackagesDir, normalizedPath(a.ExtractTarget))
+			installedTarget = targetdir
+			// earlier artifacts of this install may have written to targetdir already
+			ours := slices.Contains(extracted, targetdir) || slices.ContainsFunc(copied, func(f string) bool {
+				return isInside(f, targetdir)
+			})
+			if ours || !isComplete(reg, targetdir) {
+				if utils.Exists(targetdir) && !ours && !slices.Contains(leftover, targetdir) {
+					leftover = append(leftover, targetdir)
+				}
+				if e := stage.extract(ctx, a, target, extractdir, targetdir); e != nil {
+					return InstalledPackage{}, e
 				}
+				if !slices.Contains(extracted, targetdir) {
+					extracted = append(extracted, targetdir)
+				}
+				log.Debug("staged %s", targetdir)
 			} else {
 				log.Info("%s already exists", targetdir)
 			}
 		case "extract-to-temp":
-			dir, err := utils.ExtractToTemp(target, a.ArtifactType)
+			dir, err := utils.ExtractToTemp(ctx, target, a.ArtifactType, a.extractOptions())
+			if dir != "" {
+				cleanDirs = append(cleanDirs, dir)
+			}
 			if err != nil {
-				log.Fatal("failed to extract %s: %s", target, err.Error())
+				return InstalledPackage{}, fmt.Errorf("failed to extract %s: %w", target, err)
 			}
-			cleanDirs = append(cleanDirs, dir)
 			log.Debug("extracted to %s", dir)
 		case "copy":
-			targetfile := filepath.Join(utils.Config.PackagesDir, normalizedPath(a.ExtractTarget), a.Name)
-			_, copyErr := utils.Copy(target, targetfile)
-			if copyErr != nil {
-				log.Fatal("failed to write %s: %s", a.Name, copyErr.Error())
+			targetfile := filepath.Join(config.PackagesDir, normalizedPath(a.ExtractTarget), a.Name)
+			if copyErr := stage.copy(target, targetfile); copyErr != nil {
+				return InstalledPackage{}, fmt.Errorf("failed to write %s: %w", a.Name, copyErr)
 			}
+			installedTarget = targetfile
+			copied = append(copied, targetfile)
+		}
+		installed = append(installed, InstalledArtifact{
+			Name:         a.Name,
+			Action:       a.Action,
+			Link:         a.Link,
+			Checksum:     a.Checksum,
+			ChecksumType: a.ChecksumType,
+			Checksums:    a.Checksums,
+			Target:       installedTarget,
+		})
+	}
+	var existing []string
+	for _, a := range installed {
+		if a.Target != "" && !slices.Contains(extracted, a.Target) && !slices.Contains(copied, a.Target) {
+			existing = append(existing, a.Target)
+		}
+	}
+	steps, e := runSteps(pkg, majorVersion, r, stage, installed, existing)
+	if e != nil {
+		return InstalledPackage{}, e
+	}
+	var scriptFiles []string
+	if r.Script != "" && r.ScriptDir != "" {
+		var e error
+		scriptFiles, e = runSetupScript(ctx, config, pkg, r, majorVersion, stage)
+		if e != nil {
+			return InstalledPackage{}, e
 		}
 	}
 
-	if r.Script == "" || r.ScriptDir == "" {
-		return
+	for _, dir := range leftover {
+		log.Warn("%s is left over from an install that did not finish, replacing it", dir)
+		if e := os.RemoveAll(dir); e != nil {
+			return InstalledPackage{}, fmt.Errorf("could not remove %s: %w", dir, e)
+		}
+	}
+	if e := stage.commit(); e != nil {
+		return InstalledPackage{}, e
 	}
+	// the extracted directories are in place now, they are removed again if the install can
+	// not be recorded
+	defer func() {
+		if err != nil {
+			utils.CleanDirs(func() []string {
+				return extracted
+			})
+		}
+	}()
 
-	scriptUrl := utils.Config.ServerUrl + r.Script
+	p := InstalledPackage{
+		Package:        pkg,
+		MajorVersion:   majorVersion,
+		PackageVersion: r.PackageVersion,
+		InstalledAt:    time.Now().UTC(),
+		Artifacts:      installed,
+		ScriptFiles:    scriptFiles,
+		StepFiles:      steps.created,
+		Binaries:       r.Binaries,
+	}
+	// only now are the extracted directories complete, until then the next install replaces them
+	for _, dir := range extracted {
+		if e := markComplete(p, dir); e != nil {
+			return InstalledPackage{}, e
+		}
+	}
+	if e := recordInstall(config, p); e != nil {
+		return p, e
+	}
+	return p, RegenerateShims(config)
+}
+
+// runSetupScript runs the script in the staging directory and returns the files it created, so
+// that they can be removed on uninstall
+func runSetupScript(ctx context.Context, config *utils.RgxConfig, pkg string, r recipe, majorVersion string, stage *staging) ([]string, error) {
+	scriptUrl := config.ServerUrl + r.Script
 	scriptBase := filepath.Base(r.Script)
-	scriptDir := filepath.Join(utils.Config.PackagesDir, normalizedPath(r.ScriptDir))
+	scriptDir := filepath.Join(config.PackagesDir, normalizedPath(r.ScriptDir))
+	stagedDir := stage.path(scriptDir)
 	packageVersion := r.PackageVersion
-	err, _ := http.SaveUrl(scriptUrl, filepath.Join(scriptDir, scriptBase))
-	if err != nil {
-		log.Fatal("failed to download %s", scriptUrl)
+	sums, e := r.scriptChecksums(config, pkg)
+	if e != nil {
+		return nil, e
+	}
+	if len(sums) == 0 {
+		log.Warn("running the setup script of %s %s without verifying it, the recipe has no checksum for it", pkg, packageVersion)
+	}
+	if e := os.MkdirAll(stagedDir, 0775); e != nil {
+		return nil, e
+	}
+	scriptFile := filepath.Join(stagedDir, scriptBase)
+	if err := http.SaveUrl(ctx, config, scriptUrl, scriptFile); err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", scriptUrl, err)
+	}
+	if e := utils.Verify(scriptFile, sums); e != nil {
+		_ = os.Remove(scriptFile)
+		return nil, e
 	}
 
 	var envmap map[string]string
 	envmap = make(map[string]string)
 	envmap["RGX_PACKAGE_MAJORVERSION"] = majorVersion
-	envmap["RGX_PACKAGE_SCRIPTDIR"] = normalizedPath(scriptDir)
-	envmap["RGX_PACKAGE_SCRIPTDIR_MSYS"] = msysPath(utils.Config.PackagesDir)
-	envmap["RGX_PACKAGES_DIR"] = utils.Config.PackagesDir
-	envmap["RGX_PACKAGES_DIR_MSYS"] = msysPath(utils.Config.PackagesDir)
-	envmap["RGX_RCFILE_DIR"] = utils.Config.RcFileDir
+	envmap["RGX_PACKAGE_SCRIPTDIR"] = normalizedPath(stagedDir)
+	envmap["RGX_PACKAGE_SCRIPTDIR_MSYS"] = msysPath(stage.packages)
+	envmap["RGX_PACKAGES_DIR"] = stage.packages
+	envmap["RGX_PACKAGES_DIR_MSYS"] = msysPath(stage.packages)
+	envmap["RGX_RCFILE_DIR"] = stage.rc
 	envmap["RGX_PACKAGE_VERSION"] = packageVersion
-	utils.RunScript(scriptBase, scriptDir, envmap)
+
+	outputs, e := snapshotScriptOutputs(pkg, majorVersion, stage, scriptDir)
+	if e != nil {
+		return nil, e
+	}
+	defer outputs.remove()
+	envmap["RGX_SCRIPT_OUTPUTS"] = outputs.declared
+	if e := utils.RunScript(ctx, scriptBase, stagedDir, envmap, config.ScriptTimeout); e != nil {
+		return nil, e
+	}
+	if e := stage.unstageRcFiles(); e != nil {
+		return nil, e
+	}
+	return outputs.changed(), nil
+}
+
+// scriptChecksums parses the checksum of the setup script, which the signature of the recipe
+// covers the script with. Without one, the script only runs if recipes need not be signed.
+func (r recipe) scriptChecksums(config *utils.RgxConfig, pkg string) ([]utils.Checksum, error) {
+	sums, e := parseChecksums(r.ScriptChecksum, "sha256", nil)
+	if e != nil {
+		return nil, e
+	}
+	if len(sums) == 0 && config.RecipeSignatures == utils.SignaturesRequire {
+		return nil, fmt.Errorf("%w: %w: the recipe for %s %s has no checksum for its setup script %s",
+			utils.ErrChecksumMismatch, utils.ErrUnverified, pkg, r.PackageVersion, r.Script)
+	}
+	return sums, nil
 }
 
 func normalizedPath(p string) string {
@@ -142,38 +278,140 @@ func msysPath(p string) string {
 }
 
 type recipe struct {
-	Script         string `json:"script"`
-	ScriptDir      string `json:"script_dir"`
-	PackageVersion string `json:"package_version"`
-	Artifacts      []struct {
-		ArtifactType  string `json:"artifact_type"`
-		Action        string `json:"action"`
-		Name          string `json:"name"`
-		Version       string `json:"version"`
-		Link          string `json:"link"`
-		Checksum      string `json:"checksum"`
-		ChecksumType  string `json:"checksum_type"`
-		ExtractDir    string `json:"extract_dir"`
-		ExtractTarget string `json:"extract_target"`
-	} `json:"artifacts"`
-}
-
-func DownloadRecipe(pkg, majorVersion string) recipe {
-	var u = "/packages/" + pkg + "/release/" + majorVersion + "/" + utils.PlatformOS() + "/" + utils.PlatformArch()
-	log.Debug("getting package details from %s", utils.Config.ServerUrl+u)
-	resp, e := http.GetText(utils.Config.ServerUrl + u)
+	Script    string `json:"script"`
+	ScriptDir string `json:"script_dir"`
+	// ScriptChecksum is checked before the script runs, in any form an artifact checksum takes,
+	// hex digests are sha256
+	ScriptChecksum string     `json:"script_checksum,omitempty"`
+	PackageVersion string     `json:"package_version"`
+	Artifacts      []artifact `json:"artifacts"`
+	// Steps run after the artifacts are installed, see step
+	Steps []step `json:"steps,omitempty"`
+	// Binaries are the executables the package exposes through shims, relative to its home directory
+	Binaries []string `json:"binaries"`
+}
+
+type artifact struct {
+	ArtifactType string `json:"artifact_type"`
+	Action       string `json:"action"`
+	Name         string `json:"name"`
+	Version      string `json:"version"`
+	Link         string `json:"link"`
+	Checksum     string `json:"checksum"`
+	ChecksumType string `json:"checksum_type"`
+	// Checksums are further checksums that must all match, "<algorithm>:<hex>" or
+	// Subresource Integrity strings like "sha512-<base64>"
+	Checksums     []string `json:"checksums,omitempty"`
+	ExtractDir    string   `json:"extract_dir"`
+	ExtractTarget string   `json:"extract_target"`
+	// StripComponents, Include and Exclude select what an extract action unpacks, see
+	// utils.ExtractOptions
+	StripComponents int      `json:"strip_components,omitempty"`
+	Include         []string `json:"include,omitempty"`
+	Exclude         []string `json:"exclude,omitempty"`
+	// SignatureLink is a detached signature the vendor publishes for the file, of SignatureType
+	// "pgp" or "minisign", see verifyVendorSignature
+	SignatureLink string `json:"signature_link,omitempty"`
+	SignatureType string `json:"signature_type,omitempty"`
+}
+
+func (a artifact) checksums() ([]utils.Checksum, error) {
+	return parseChecksums(a.Checksum, a.ChecksumType, a.Checksums)
+}
+
+// parseChecksums reads the checksum of an artifact, which may also be a Subresource Integrity
+// string, followed by its further checksums
+func parseChecksums(checksum, checksumType string, more []string) ([]utils.Checksum, error) {
+	var sums []utils.Checksum
+	if strings.TrimSpace(checksum) != "" {
+		c, e := utils.ParseChecksum(checksum, checksumType)
+		if e != nil {
+			return nil, e
+		}
+		sums = append(sums, c)
+	}
+	for _, s := range more {
+		c, e := utils.ParseChecksum(s, "")
+		if e != nil {
+			return nil, e
+		}
+		sums = append(sums, c)
+	}
+	return sums, nil
+}
+
+func (a artifact) extractOptions() utils.ExtractOptions {
+	return utils.ExtractOptions{StripComponents: a.StripComponents, Include: a.Include, Exclude: a.Exclude}
+}
+
+func DownloadRecipe(ctx context.Context, config *utils.RgxConfig, pkg, majorVersion string) (recipe, error) {
+	r, found, e := fetchRecipe(ctx, config, pkg, majorVersion, utils.PlatformOS(), utils.PlatformArch())
+	if e != nil {
+		return r, e
+	}
+	if !found {
+		return r, fmt.Errorf("%w: %s version %s (%s/%s)", ErrPackageNotFound, pkg, majorVersion, utils.PlatformOS(), utils.PlatformArch())
+	}
+	return r, nil
+}
+
+// fetchRecipe gets the recipe for any platform; found is false if the server doesn't know it
+func fetchRecipe(ctx context.Context, config *utils.RgxConfig, pkg, majorVersion, opsys, arch string) (r recipe, found bool, e error) {
+	var u = "/packages/" + pkg + "/release/" + majorVersion + "/" + opsys + "/" + arch
+	log.Debug("getting package details from %s", config.ServerUrl+u)
+	resp, e := http.GetText(ctx, config, config.ServerUrl+u)
 	if e != nil {
-		if resp.ResponseCode == 404 {
-			log.Fatal("package not found %s version %s (%s%s)", pkg, majorVersion, utils.PlatformOS(), utils.PlatformArch())
-		} else {
-			log.Fatal("invalid server response: %s", e.Error())
+		if errors.Is(e, http.ErrNotFound) {
+			return r, false, nil
 		}
+		return r, true, e
 	}
 
-	var r recipe
+	if e := verifyRecipe(config, pkg, majorVersion, opsys, arch, resp); e != nil {
+		return r, true, e
+	}
 	err := json.Unmarshal([]byte(resp.Text), &r)
 	if err != nil {
-		log.Fatal("could not parse json: " + err.Error())
+		return r, true, fmt.Errorf("%w: could not parse json: %s", http.ErrBadResponse, err.Error())
+	}
+	return r, true, nil
+}
+
+// recipeDiff describes the first difference between two recipes, or returns "" if they
+// would install the same files
+func recipeDiff(a, b recipe) string {
+	if a.PackageVersion != b.PackageVersion {
+		return fmt.Sprintf("package version %s != %s", a.PackageVersion, b.PackageVersion)
+	}
+	if !reflect.DeepEqual(a.Steps, b.Steps) {
+		return "post-install steps"
+	}
+	if a.Script != b.Script || a.ScriptDir != b.ScriptDir {
+		return fmt.Sprintf("setup script %s in %s != %s in %s", a.Script, a.ScriptDir, b.Script, b.ScriptDir)
+	}
+	if a.ScriptChecksum != b.ScriptChecksum {
+		return fmt.Sprintf("checksum of the setup script %s != %s", a.ScriptChecksum, b.ScriptChecksum)
+	}
+	if !slices.Equal(a.Binaries, b.Binaries) {
+		return fmt.Sprintf("binaries %s != %s", strings.Join(a.Binaries, ", "), strings.Join(b.Binaries, ", "))
+	}
+	if len(a.Artifacts) != len(b.Artifacts) {
+		return fmt.Sprintf("%d artifacts != %d artifacts", len(a.Artifacts), len(b.Artifacts))
+	}
+	for i := range a.Artifacts {
+		x, y := a.Artifacts[i], b.Artifacts[i]
+		switch {
+		case x.Link != y.Link:
+			return fmt.Sprintf("link %s != %s", x.Link, y.Link)
+		case x.Checksum != y.Checksum || x.ChecksumType != y.ChecksumType || !slices.Equal(x.Checksums, y.Checksums):
+			return fmt.Sprintf("checksum of %s %s:%s != %s:%s", x.Name, x.ChecksumType, x.Checksum, y.ChecksumType, y.Checksum)
+		case x.Action != y.Action || x.ExtractDir != y.ExtractDir || x.ExtractTarget != y.ExtractTarget:
+			return fmt.Sprintf("install location of %s", x.Name)
+		case x.SignatureLink != y.SignatureLink || x.SignatureType != y.SignatureType:
+			return fmt.Sprintf("signature of %s", x.Name)
+		case x.StripComponents != y.StripComponents || !slices.Equal(x.Include, y.Include) || !slices.Equal(x.Exclude, y.Exclude):
+			return fmt.Sprintf("extracted files of %s", x.Name)
+		}
 	}
-	return r
+	return ""
 }
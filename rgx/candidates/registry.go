@@ -0,0 +1,174 @@
+package candidates
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"rgx/common/log"
+	"rgx/common/utils"
+)
+
+// registryFile is kept in the packages directory and records every successful install
+const registryFile = ".rgx-installed.json"
+
+type InstalledArtifact struct {
+	Name         string   `json:"name"`
+	Action       string   `json:"action"`
+	Link         string   `json:"link"`
+	Checksum     string   `json:"checksum,omitempty"`
+	ChecksumType string   `json:"checksum_type,omitempty"`
+	Checksums    []string `json:"checksums,omitempty"`
+	Target       string   `json:"target,omitempty"`
+}
+
+type InstalledPackage struct {
+	Package        string              `json:"package"`
+	MajorVersion   string              `json:"major_version"`
+	PackageVersion string              `json:"package_version"`
+	InstalledAt    time.Time           `json:"installed_at"`
+	UpdatedAt      time.Time           `json:"updated_at"`
+	Artifacts      []InstalledArtifact `json:"artifacts"`
+	ScriptFiles    []string            `json:"script_files,omitempty"`
+	StepFiles      []string            `json:"step_files,omitempty"`
+	Binaries       []string            `json:"binaries,omitempty"`
+}
+
+type Registry struct {
+	Packages []InstalledPackage `json:"packages"`
+	// Active maps a package name to the package version that `rgx use` selected
+	Active map[string]string `json:"active,omitempty"`
+	// path is the file the registry was read from and is saved to
+	path string
+}
+
+func registryPath(config *utils.RgxConfig) string {
+	return filepath.Join(config.PackagesDir, registryFile)
+}
+
+func ReadRegistry(config *utils.RgxConfig) (Registry, error) {
+	reg := Registry{path: registryPath(config)}
+	b, e := os.ReadFile(reg.path)
+	if os.IsNotExist(e) {
+		return reg, nil
+	}
+	if e != nil {
+		return reg, fmt.Errorf("%w: could not read %s: %s", ErrRegistry, reg.path, e.Error())
+	}
+	if e = json.Unmarshal(b, &reg); e != nil {
+		return reg, fmt.Errorf("%w: could not parse %s: %s", ErrRegistry, reg.path, e.Error())
+	}
+	return reg, nil
+}
+
+func (reg *Registry) Save() error {
+	b, e := json.MarshalIndent(reg, "", "  ")
+	if e != nil {
+		return fmt.Errorf("%w: %s", ErrRegistry, e.Error())
+	}
+	// write to a temp file first, so that an interrupted write never corrupts the registry
+	tempFile := reg.path + ".tmp"
+	e = os.WriteFile(tempFile, b, 0664)
+	if e != nil {
+		return fmt.Errorf("%w: could not write %s: %s", ErrRegistry, tempFile, e.Error())
+	}
+	e = os.Rename(tempFile, reg.path)
+	if e != nil {
+		return fmt.Errorf("%w: could not write %s: %s", ErrRegistry, reg.path, e.Error())
+	}
+	return nil
+}
+
+// Record adds p to the registry, replacing any earlier install of the same package version
+func (reg *Registry) Record(p InstalledPackage) {
+	for i, x := range reg.Packages {
+		if x.Package == p.Package && x.PackageVersion == p.PackageVersion {
+			p.InstalledAt = x.InstalledAt
+			// the steps of a reinstall leave what the first install created alone
+			for _, f := range x.StepFiles {
+				if !slices.Contains(p.StepFiles, f) {
+					p.StepFiles = append(p.StepFiles, f)
+				}
+			}
+			reg.Packages[i] = p
+			return
+		}
+	}
+	reg.Packages = append(reg.Packages, p)
+}
+
+func (reg *Registry) Remove(p InstalledPackage) {
+	for i, x := range reg.Packages {
+		if x.Package == p.Package && x.PackageVersion == p.PackageVersion {
+			reg.Packages = append(reg.Packages[:i], reg.Packages[i+1:]...)
+			return
+		}
+	}
+}
+
+func (reg *Registry) SetActive(pkg, packageVersion string) {
+	if reg.Active == nil {
+		reg.Active = make(map[string]string)
+	}
+	reg.Active[pkg] = packageVersion
+}
+
+func (reg Registry) Find(pkg, version string) []InstalledPackage {
+	var found []InstalledPackage
+	for _, x := range reg.Packages {
+		if x.Package == pkg && (x.PackageVersion == version || x.MajorVersion == version) {
+			found = append(found, x)
+		}
+	}
+	return found
+}
+
+func (reg Registry) Sorted() []InstalledPackage {
+	pkgs := make([]InstalledPackage, len(reg.Packages))
+	copy(pkgs, reg.Packages)
+	sort.SliceStable(pkgs, func(i, j int) bool {
+		if pkgs[i].Package != pkgs[j].Package {
+			return pkgs[i].Package < pkgs[j].Package
+		}
+		return compareVersions(pkgs[i].PackageVersion, pkgs[j].PackageVersion) < 0
+	})
+	return pkgs
+}
+
+func recordInstall(config *utils.RgxConfig, p InstalledPackage) error {
+	p.UpdatedAt = p.InstalledAt
+	reg, e := ReadRegistry(config)
+	if e != nil {
+		return e
+	}
+	reg.Record(p)
+	if e = reg.Save(); e != nil {
+		return e
+	}
+	log.Debug("recorded %s %s in %s", p.Package, p.PackageVersion, reg.path)
+	return nil
+}
+
+// Installed lists every installed package, ordered by package and version
+func Installed(config *utils.RgxConfig) ([]InstalledPackage, error) {
+	reg, e := ReadRegistry(config)
+	if e != nil {
+		return nil, e
+	}
+	return reg.Sorted(), nil
+}
+
+// Targets lists the directories and files the artifacts of p were installed to
+func (p InstalledPackage) Targets() []string {
+	var targets []string
+	for _, a := range p.Artifacts {
+		if a.Target != "" {
+			targets = append(targets, a.Target)
+		}
+	}
+	return targets
+}
@@ -0,0 +1,157 @@
+package candidates
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"rgx/common/log"
+	"rgx/common/utils"
+	"rgx/common/version"
+)
+
+// resolveRecipe turns what the user asked for into a major version, the version to ask the
+// server for to get the same recipe again, and the recipe to install. It understands the
+// aliases latest, stable and lts, exact releases such as 1.22.3, major versions such as 1.22,
+// wildcards such as 1.22.x, and ranges such as ~1.21 or >=1.20 <1.23.
+func resolveRecipe(ctx context.Context, config *utils.RgxConfig, pkg, suppliedVersion string, lts bool) (string, string, recipe, error) {
+	if suppliedVersion == "lts" {
+		lts = true
+	}
+	releases, ok, e := Releases(ctx, config, pkg, lts)
+	if e != nil {
+		return "", "", recipe{}, e
+	}
+	if !ok {
+		return resolveRecipeFromMajorVersions(ctx, config, pkg, suppliedVersion, lts)
+	}
+
+	release, e := resolveRelease(pkg, suppliedVersion, releases)
+	if e != nil {
+		return "", "", recipe{}, e
+	}
+	if release != suppliedVersion {
+		log.Info("%s %s -> %s", pkg, suppliedVersion, release)
+	}
+	r, e := DownloadRecipe(ctx, config, pkg, release)
+	if e != nil {
+		return "", "", r, e
+	}
+	if r.PackageVersion != release {
+		return "", "", r, fmt.Errorf("%w: asked the server for %s %s, but got %s", ErrNoMatchingVersion, pkg, release, r.PackageVersion)
+	}
+	majorVersion, e := majorVersionOf(ctx, config, pkg, release, lts)
+	return majorVersion, release, r, e
+}
+
+// resolveRelease picks the newest of releases that matches suppliedVersion
+func resolveRelease(pkg, suppliedVersion string, releases []string) (string, error) {
+	spec := suppliedVersion
+	if spec == "latest" || spec == "stable" || spec == "lts" {
+		spec = "*"
+	}
+	c, e := version.ParseConstraint(spec)
+	if e != nil {
+		return "", e
+	}
+
+	var best version.Version
+	var bestRelease string
+	for _, r := range releases {
+		v, e := version.Parse(r)
+		if e != nil {
+			log.Debug("ignoring release %s of %s: %s", r, pkg, e.Error())
+			continue
+		}
+		// the aliases mean the newest stable release, only a constraint that names a
+		// pre-release itself, such as 1.24rc1 or >=1.24.0-0, selects one
+		if c.Check(v) && (bestRelease == "" || best.Less(v)) {
+			best, bestRelease = v, r
+		}
+	}
+	if bestRelease == "" {
+		return "", fmt.Errorf("%w: no release of %s matches %s", ErrNoMatchingVersion, pkg, suppliedVersion)
+	}
+	return bestRelease, nil
+}
+
+// majorVersionOf finds the major version a release belongs to, e.g. 1.22 for 1.22.3
+func majorVersionOf(ctx context.Context, config *utils.RgxConfig, pkg, release string, lts bool) (string, error) {
+	majorVersions, e := MajorVersions(ctx, config, pkg, lts)
+	if e != nil {
+		return "", e
+	}
+	majorVersion := release
+	for _, m := range majorVersions {
+		if strings.HasPrefix(release, m+".") && len(m) < len(majorVersion) {
+			majorVersion = m
+		}
+	}
+	return majorVersion, nil
+}
+
+// resolveRecipeFromMajorVersions is used for packages where the server only offers the
+// newest release of each major version
+func resolveRecipeFromMajorVersions(ctx context.Context, config *utils.RgxConfig, pkg, suppliedVersion string, lts bool) (string, string, recipe, error) {
+	if !version.IsConstraint(suppliedVersion) {
+		majorVersion, e := resolveMajorVersion(ctx, config, pkg, suppliedVersion, lts)
+		if e != nil {
+			return "", "", recipe{}, e
+		}
+		r, e := DownloadRecipe(ctx, config, pkg, majorVersion)
+		if e != nil {
+			return "", "", r, e
+		}
+		if r.PackageVersion != suppliedVersion {
+			log.Info("%s %s -> %s", pkg, suppliedVersion, r.PackageVersion)
+		}
+		return majorVersion, majorVersion, r, nil
+	}
+
+	c, e := version.ParseConstraint(suppliedVersion)
+	if e != nil {
+		return "", "", recipe{}, e
+	}
+	majorVersions, e := MajorVersions(ctx, config, pkg, lts)
+	if e != nil {
+		return "", "", recipe{}, e
+	}
+	for i := len(majorVersions) - 1; i >= 0; i-- {
+		m, e := version.Parse(majorVersions[i])
+		if e != nil || !c.AllowsSeries(m) {
+			continue
+		}
+		r, found, e := fetchRecipe(ctx, config, pkg, majorVersions[i], utils.PlatformOS(), utils.PlatformArch())
+		if e != nil {
+			return "", "", r, e
+		}
+		if !found {
+			continue
+		}
+		if v, e := version.Parse(r.PackageVersion); e == nil && c.Check(v) {
+			log.Info("%s %s -> %s", pkg, suppliedVersion, r.PackageVersion)
+			return majorVersions[i], majorVersions[i], r, nil
+		}
+		log.Debug("%s %s does not satisfy %s", pkg, r.PackageVersion, c)
+	}
+	return "", "", recipe{}, fmt.Errorf("%w: no version of %s satisfies %s", ErrNoMatchingVersion, pkg, suppliedVersion)
+}
+
+func resolveMajorVersion(ctx context.Context, config *utils.RgxConfig, pkg, suppliedMajorVersion string, lts bool) (string, error) {
+	switch suppliedMajorVersion {
+	case "latest", "stable", "lts":
+	default:
+		return suppliedMajorVersion, nil
+	}
+	versions, e := MajorVersions(ctx, config, pkg, lts)
+	if e != nil {
+		return "", e
+	}
+	for i := len(versions) - 1; i >= 0; i-- {
+		if v, e := version.Parse(versions[i]); e == nil && v.PreRelease != "" {
+			continue
+		}
+		return versions[i], nil
+	}
+	return "", fmt.Errorf("%w: no versions found for package: %s", ErrNoMatchingVersion, pkg)
+}
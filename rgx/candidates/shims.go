@@ -0,0 +1,161 @@
+package candidates
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"rgx/common/log"
+	"rgx/common/utils"
+)
+
+// shimMarker identifies files in the shims directory that rgx is free to overwrite or delete
+const shimMarker = "generated by rgx, do not edit"
+
+// binaryName is the name a shim is created under, e.g. go for go/bin/go.exe
+func binaryName(binary string) string {
+	base := filepath.Base(filepath.FromSlash(binary))
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+func shimPath(config *utils.RgxConfig, name string) string {
+	if utils.PlatformOS() == "windows" {
+		return filepath.Join(config.ShimsDir, name+".cmd")
+	}
+	return filepath.Join(config.ShimsDir, name)
+}
+
+func shimContents(rgxExe, pkg, name string) string {
+	if utils.PlatformOS() == "windows" {
+		return fmt.Sprintf("@echo off\r\nrem %s\r\n\"%s\" exec %s %s %%*\r\n", shimMarker, rgxExe, pkg, name)
+	}
+	return fmt.Sprintf("#!/bin/sh\n# %s\nexec \"%s\" exec %s %s \"$@\"\n", shimMarker, rgxExe, pkg, name)
+}
+
+// RegenerateShims writes a launcher for every binary exposed by an installed package, and
+// removes launchers for binaries that are no longer installed
+func RegenerateShims(config *utils.RgxConfig) error {
+	rgxExe, e := os.Executable()
+	if e != nil {
+		return e
+	}
+	reg, e := ReadRegistry(config)
+	if e != nil {
+		return e
+	}
+
+	owners := make(map[string]string)
+	for _, p := range reg.Sorted() {
+		for _, b := range p.Binaries {
+			name := binaryName(b)
+			if owner, ok := owners[name]; ok && owner != p.Package {
+				log.Warn("%s is exposed by both %s and %s, the shim will run %s", name, owner, p.Package, owner)
+				continue
+			}
+			owners[name] = p.Package
+		}
+	}
+
+	if e = os.MkdirAll(config.ShimsDir, 0775); e != nil {
+		return e
+	}
+	wanted := make(map[string]bool)
+	for name, pkg := range owners {
+		path := shimPath(config, name)
+		wanted[path] = true
+		if utils.Exists(path) && !isShim(path) {
+			log.Warn("not overwriting %s, it was not created by rgx", path)
+			continue
+		}
+		if e := os.WriteFile(path, []byte(shimContents(rgxExe, pkg, name)), 0775); e != nil {
+			return fmt.Errorf("could not write shim %s: %w", path, e)
+		}
+		log.Trace("wrote shim %s for %s", path, pkg)
+	}
+
+	entries, e := os.ReadDir(config.ShimsDir)
+	if e != nil {
+		return e
+	}
+	for _, entry := range entries {
+		path := filepath.Join(config.ShimsDir, entry.Name())
+		if wanted[path] || !isShim(path) {
+			continue
+		}
+		if e := os.Remove(path); e != nil {
+			log.Warn("could not remove stale shim %s: %s", path, e.Error())
+		}
+	}
+
+	if len(owners) > 0 && !onPath(config.ShimsDir) {
+		log.Info("add %s to your PATH to use the installed packages", config.ShimsDir)
+	}
+	return nil
+}
+
+func isShim(path string) bool {
+	b, e := os.ReadFile(path)
+	return e == nil && strings.Contains(string(b), shimMarker)
+}
+
+func onPath(dir string) bool {
+	for _, p := range filepath.SplitList(os.Getenv("PATH")) {
+		if filepath.Clean(p) == filepath.Clean(dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// Which returns the full path of binary name in the version of pkg that is currently in use
+func Which(config *utils.RgxConfig, pkg, name string) (string, error) {
+	p, e := resolveInstall(config, pkg)
+	if e != nil {
+		return "", e
+	}
+	home := homeDir(p)
+	for _, b := range p.Binaries {
+		if binaryName(b) == name {
+			return filepath.Join(home, filepath.FromSlash(b)), nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s %s does not provide %s", ErrNotInstalled, pkg, p.PackageVersion, name)
+}
+
+// resolveInstall finds the install of pkg to use: the version pinned by the project file,
+// then the active version, and otherwise the highest installed version
+func resolveInstall(config *utils.RgxConfig, pkg string) (InstalledPackage, error) {
+	reg, e := ReadRegistry(config)
+	if e != nil {
+		return InstalledPackage{}, e
+	}
+	version, fname, ok, e := Pinned(pkg)
+	if e != nil {
+		return InstalledPackage{}, e
+	}
+	if ok {
+		found := reg.Find(pkg, version)
+		if len(found) == 0 {
+			return InstalledPackage{}, fmt.Errorf("%w: %s %s is pinned by %s, run: rgx install", ErrNotInstalled, pkg, version, fname)
+		}
+		return latestInstall(found), nil
+	}
+	if version, ok := reg.Active[pkg]; ok {
+		if found := reg.Find(pkg, version); len(found) > 0 {
+			return found[0], nil
+		}
+		log.Warn("active version %s of %s is no longer installed", version, pkg)
+	}
+
+	var found []InstalledPackage
+	for _, p := range reg.Packages {
+		if p.Package == pkg {
+			found = append(found, p)
+		}
+	}
+	if len(found) == 0 {
+		return InstalledPackage{}, fmt.Errorf("%w: %s", ErrNotInstalled, pkg)
+	}
+	return latestInstall(found), nil
+}
@@ -0,0 +1,191 @@
+package candidates
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"golang.org/x/crypto/blake2b"
+
+	"rgx/common/http"
+	"rgx/common/log"
+	"rgx/common/utils"
+)
+
+// Artifacts can declare a detached signature published by their vendor, which is checked
+// with a key rgx.toml pins for the package in [vendor_keys]. Without a pinned key the
+// signature is ignored, since a key named by the server is no better than its checksums.
+const (
+	signaturePGP      = "pgp"
+	signatureMinisign = "minisign"
+)
+
+// verifyRecipe checks the signature the server sent with a recipe, according to
+// recipe_signatures in rgx.toml
+func verifyRecipe(config *utils.RgxConfig, pkg, release, opsys, arch string, resp http.TextResponse) error {
+	policy := config.RecipeSignatures
+	if policy == utils.SignaturesOff {
+		return nil
+	}
+	if len(config.TrustedKeys) == 0 && policy == utils.SignaturesRequire {
+		file := config.ConfigFile
+		if file == "" {
+			file = "rgx.toml"
+		}
+		return fmt.Errorf("%w: recipes must be signed, but %s pins no key. The rgx server at %s logs its key "+
+			"when it starts, as \"signing recipes with key <id>: ed25519:<base64>\"; add it to %s as "+
+			"trusted_keys = [\"ed25519:<base64>\"]. To install unsigned recipes instead, set "+
+			"recipe_signatures = \"warn\" there", utils.ErrConfig, file, config.ServerUrl, file)
+	}
+
+	message := utils.RecipeMessage(pkg, release, opsys, arch, []byte(resp.Text))
+	id, e := utils.VerifySignature(config.TrustedKeys, message, resp.Header.Get(utils.SignatureHeader))
+	if e == nil {
+		log.Debug("the recipe for %s %s (%s/%s) is signed by %s", pkg, release, opsys, arch, id)
+		return nil
+	}
+	if policy == utils.SignaturesWarn {
+		log.Warn("the recipe for %s %s (%s/%s): %s", pkg, release, opsys, arch, e.Error())
+		return nil
+	}
+	return fmt.Errorf("the recipe for %s %s (%s/%s): %w", pkg, release, opsys, arch, e)
+}
+
+// verifyVendorSignature checks the file of an artifact against the signature its vendor
+// publishes, if rgx.toml pins a key for pkg
+func verifyVendorSignature(ctx context.Context, config *utils.RgxConfig, pkg string, a artifact, path string) error {
+	if a.SignatureLink == "" {
+		return nil
+	}
+	keyFile, ok := config.VendorKeys[pkg]
+	if !ok {
+		log.Debug("not checking the signature of %s, no key for %s in vendor_keys", a.Name, pkg)
+		return nil
+	}
+	key, e := os.ReadFile(keyFile)
+	if e != nil {
+		return fmt.Errorf("%w: could not read the key for %s: %w", utils.ErrConfig, pkg, e)
+	}
+
+	sigFile, e := os.CreateTemp("", "rgx-*.sig")
+	if e != nil {
+		return e
+	}
+	_ = sigFile.Close()
+	defer func() { _ = os.Remove(sigFile.Name()) }()
+	if e := http.SaveUrl(ctx, config, a.SignatureLink, sigFile.Name()); errors.Is(e, http.ErrNotFound) {
+		return fmt.Errorf("%w: %s has no signature at %s", utils.ErrSignature, a.Name, a.SignatureLink)
+	} else if e != nil {
+		return fmt.Errorf("could not download the signature of %s: %w", a.Name, e)
+	}
+	sig, e := os.ReadFile(sigFile.Name())
+	if e != nil {
+		return e
+	}
+
+	switch a.SignatureType {
+	case signaturePGP:
+		e = verifyPGP(path, key, sig)
+	case signatureMinisign:
+		e = verifyMinisign(path, key, sig)
+	default:
+		e = fmt.Errorf("%w: unsupported signature type %q", utils.ErrSignature, a.SignatureType)
+	}
+	if e != nil {
+		return fmt.Errorf("%s: %w", a.Name, e)
+	}
+	log.Debug("%s is signed by its vendor", a.Name)
+	return nil
+}
+
+func verifyPGP(path string, key, sig []byte) error {
+	keyring, e := openpgp.ReadArmoredKeyRing(bytes.NewReader(key))
+	if e != nil {
+		return fmt.Errorf("%w: could not read the pgp key: %s", utils.ErrSignature, e.Error())
+	}
+	f, e := os.Open(path)
+	if e != nil {
+		return e
+	}
+	defer func() { _ = f.Close() }()
+
+	check := openpgp.CheckDetachedSignature
+	if bytes.Contains(sig, []byte("-----BEGIN PGP SIGNATURE-----")) {
+		check = openpgp.CheckArmoredDetachedSignature
+	}
+	if _, e = check(keyring, f, bytes.NewReader(sig), nil); e != nil {
+		return fmt.Errorf("%w: %s", utils.ErrSignature, e.Error())
+	}
+	return nil
+}
+
+// verifyMinisign checks a minisign signature: the signature of the file, or of its blake2b-512
+// hash for prehashed signatures, and the signature of the trusted comment
+func verifyMinisign(path string, key, sig []byte) error {
+	pub, e := minisignBlob(key)
+	if e != nil || len(pub) != 42 {
+		return fmt.Errorf("%w: could not read the minisign key", utils.ErrSignature)
+	}
+	s, e := minisignBlob(sig)
+	if e != nil || len(s) != 74 {
+		return fmt.Errorf("%w: could not read the minisign signature", utils.ErrSignature)
+	}
+	if !bytes.Equal(pub[2:10], s[2:10]) {
+		return fmt.Errorf("%w: signed with another key", utils.ErrSignature)
+	}
+	publicKey := ed25519.PublicKey(pub[10:])
+
+	f, e := os.Open(path)
+	if e != nil {
+		return e
+	}
+	defer func() { _ = f.Close() }()
+	var message []byte
+	switch string(s[:2]) {
+	case "ED":
+		h, _ := blake2b.New512(nil)
+		if _, e := io.Copy(h, f); e != nil {
+			return e
+		}
+		message = h.Sum(nil)
+	case "Ed":
+		if message, e = io.ReadAll(f); e != nil {
+			return e
+		}
+	default:
+		return fmt.Errorf("%w: unsupported minisign algorithm %q", utils.ErrSignature, s[:2])
+	}
+	if !ed25519.Verify(publicKey, message, s[10:]) {
+		return fmt.Errorf("%w: bad signature", utils.ErrSignature)
+	}
+
+	lines := strings.Split(strings.ReplaceAll(string(sig), "\r\n", "\n"), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return fmt.Errorf("%w: the minisign signature has no trusted comment", utils.ErrSignature)
+	}
+	global, e := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	signed := append(slices.Clip(s[10:]), strings.TrimPrefix(lines[2], "trusted comment: ")...)
+	if e != nil || !ed25519.Verify(publicKey, signed, global) {
+		return fmt.Errorf("%w: bad signature of the trusted comment", utils.ErrSignature)
+	}
+	return nil
+}
+
+// minisignBlob decodes the first line of a minisign file that is not a comment
+func minisignBlob(b []byte) ([]byte, error) {
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "untrusted comment:") {
+			return base64.StdEncoding.DecodeString(line)
+		}
+	}
+	return nil, fmt.Errorf("no data")
+}
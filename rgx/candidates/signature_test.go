@@ -0,0 +1,264 @@
+package candidates
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	nethttp "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"golang.org/x/crypto/blake2b"
+
+	"rgx/common/http"
+	"rgx/common/utils"
+)
+
+// minisignKey signs files the way minisign does
+type minisignKey struct {
+	id      []byte
+	private ed25519.PrivateKey
+}
+
+func newMinisignKey(t *testing.T, id string) minisignKey {
+	t.Helper()
+	_, private, e := ed25519.GenerateKey(rand.Reader)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return minisignKey{id: []byte(id), private: private}
+}
+
+// publicKey is the content of a minisign .pub file
+func (k minisignKey) publicKey() []byte {
+	blob := append([]byte("Ed"), k.id...)
+	blob = append(blob, k.private.Public().(ed25519.PublicKey)...)
+	return []byte("untrusted comment: minisign public key\n" + base64.StdEncoding.EncodeToString(blob) + "\n")
+}
+
+// sign returns a .minisig file for content, prehashed as minisign does by default
+func (k minisignKey) sign(content []byte, prehashed bool) []byte {
+	algorithm, message := "Ed", content
+	if prehashed {
+		h := blake2b.Sum512(content)
+		algorithm, message = "ED", h[:]
+	}
+	sig := ed25519.Sign(k.private, message)
+	blob := append(append([]byte(algorithm), k.id...), sig...)
+	comment := "timestamp:1700000000"
+	global := ed25519.Sign(k.private, append(sig, comment...))
+	return []byte("untrusted comment: signature\n" + base64.StdEncoding.EncodeToString(blob) + "\n" +
+		"trusted comment: " + comment + "\n" + base64.StdEncoding.EncodeToString(global) + "\n")
+}
+
+// fileServer serves files by path, and 404 for everything else
+func fileServer(t *testing.T, files map[string][]byte) *httptest.Server {
+	srv := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		b, ok := files[r.URL.Path]
+		if !ok {
+			nethttp.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(b)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// vendorConfig pins key as the vendor key of the package demo
+func vendorConfig(t *testing.T, key []byte) *utils.RgxConfig {
+	t.Helper()
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "demo.pub")
+	if e := os.WriteFile(keyFile, key, 0644); e != nil {
+		t.Fatal(e)
+	}
+	return &utils.RgxConfig{
+		DownloadDir:          filepath.Join(dir, "downloads"),
+		HttpTimeout:          5 * time.Second,
+		MaxParallelDownloads: 2,
+		VendorKeys:           map[string]string{"demo": keyFile},
+	}
+}
+
+// pgpKey returns a pgp key and its armored public key
+func pgpKey(t *testing.T) (*openpgp.Entity, []byte) {
+	t.Helper()
+	entity, e := openpgp.NewEntity("vendor", "", "vendor@example.com", &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+	if e != nil {
+		t.Fatal(e)
+	}
+	var b bytes.Buffer
+	w, e := armor.Encode(&b, openpgp.PublicKeyType, nil)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e := entity.Serialize(w); e != nil {
+		t.Fatal(e)
+	}
+	if e := w.Close(); e != nil {
+		t.Fatal(e)
+	}
+	return entity, b.Bytes()
+}
+
+func pgpSign(t *testing.T, entity *openpgp.Entity, content []byte, armored bool) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	sign := openpgp.DetachSign
+	if armored {
+		sign = openpgp.ArmoredDetachSign
+	}
+	if e := sign(&b, entity, bytes.NewReader(content), nil); e != nil {
+		t.Fatal(e)
+	}
+	return b.Bytes()
+}
+
+func TestVerifyRecipe(t *testing.T) {
+	public, private, e := ed25519.GenerateKey(rand.Reader)
+	if e != nil {
+		t.Fatal(e)
+	}
+	key, e := utils.ParsePublicKey("ed25519:" + base64.StdEncoding.EncodeToString(public))
+	if e != nil {
+		t.Fatal(e)
+	}
+	_, otherPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	body := `{"package_version": "1.0.3"}`
+	sign := func(private ed25519.PrivateKey, pkg string) string {
+		sig := ed25519.Sign(private, utils.RecipeMessage(pkg, "1", "linux", "x64", []byte(body)))
+		return key.ID + ":" + base64.StdEncoding.EncodeToString(sig)
+	}
+
+	tests := []struct {
+		name   string
+		keys   []utils.PublicKey
+		policy string
+		header string
+		want   error
+	}{
+		{"good", []utils.PublicKey{key}, utils.SignaturesRequire, sign(private, "demo"), nil},
+		{"good among several", []utils.PublicKey{key}, utils.SignaturesRequire, "0011223344556677:AAAA, " + sign(private, "demo"), nil},
+		{"another key", []utils.PublicKey{key}, utils.SignaturesRequire, sign(otherPrivate, "demo"), utils.ErrSignature},
+		{"another package", []utils.PublicKey{key}, utils.SignaturesRequire, sign(private, "other"), utils.ErrSignature},
+		{"garbled", []utils.PublicKey{key}, utils.SignaturesRequire, key.ID + ":not base64", utils.ErrSignature},
+		{"missing", []utils.PublicKey{key}, utils.SignaturesRequire, "", utils.ErrSignature},
+		{"no trusted key", nil, utils.SignaturesRequire, sign(private, "demo"), utils.ErrConfig},
+		{"bad with warn", []utils.PublicKey{key}, utils.SignaturesWarn, sign(otherPrivate, "demo"), nil},
+		{"missing with warn", nil, utils.SignaturesWarn, "", nil},
+		{"missing with off", nil, utils.SignaturesOff, "", nil},
+	}
+	for _, test := range tests {
+		config := &utils.RgxConfig{TrustedKeys: test.keys, RecipeSignatures: test.policy}
+		resp := http.TextResponse{Text: body, ResponseCode: 200, Header: nethttp.Header{}}
+		if test.header != "" {
+			resp.Header.Set(utils.SignatureHeader, test.header)
+		}
+		e := verifyRecipe(config, "demo", "1", "linux", "x64", resp)
+		if test.want == nil && e != nil || test.want != nil && !errors.Is(e, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, e, test.want)
+		}
+	}
+}
+
+func TestVerifyRecipeExplainsHowToPinAKey(t *testing.T) {
+	config := &utils.RgxConfig{ConfigFile: "/opt/rgx/rgx.toml", ServerUrl: "http://rgx.example.com", RecipeSignatures: utils.SignaturesRequire}
+	e := verifyRecipe(config, "demo", "1", "linux", "x64", http.TextResponse{Header: nethttp.Header{}})
+	for _, s := range []string{"/opt/rgx/rgx.toml", "http://rgx.example.com", `trusted_keys = ["ed25519:<base64>"]`, `recipe_signatures = "warn"`} {
+		if e == nil || !strings.Contains(e.Error(), s) {
+			t.Errorf("the error does not mention %s: %v", s, e)
+		}
+	}
+}
+
+func TestVerifyVendorSignature(t *testing.T) {
+	content := []byte("the artifact")
+	minisign, otherMinisign := newMinisignKey(t, "12345678"), newMinisignKey(t, "12345678")
+	strangerMinisign := newMinisignKey(t, "87654321")
+	tampered := bytes.Replace(minisign.sign(content, true), []byte("timestamp:"), []byte("timestamp:9"), 1)
+	entity, pgpPublic := pgpKey(t)
+	otherEntity, _ := pgpKey(t)
+	srv := fileServer(t, map[string][]byte{
+		"/minisign/good":        minisign.sign(content, true),
+		"/minisign/legacy":      minisign.sign(content, false),
+		"/minisign/other":       minisign.sign([]byte("another file"), true),
+		"/minisign/forged":      otherMinisign.sign(content, true),
+		"/minisign/stranger":    strangerMinisign.sign(content, true),
+		"/minisign/tampered":    tampered,
+		"/minisign/garbage":     []byte("not a signature"),
+		"/pgp/armored":          pgpSign(t, entity, content, true),
+		"/pgp/binary":           pgpSign(t, entity, content, false),
+		"/pgp/other":            pgpSign(t, entity, []byte("another file"), true),
+		"/pgp/forged":           pgpSign(t, otherEntity, content, true),
+		"/pgp/garbage":          []byte("not a signature"),
+		"/minisign.pub":         minisign.publicKey(),
+		"/pgp.asc":              pgpPublic,
+		"/unsupported/anything": []byte("x"),
+	})
+	path := filepath.Join(t.TempDir(), "artifact")
+	if e := os.WriteFile(path, content, 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	tests := []struct {
+		name          string
+		signatureType string
+		link          string
+		want          error
+	}{
+		{"minisign", signatureMinisign, "/minisign/good", nil},
+		{"legacy minisign", signatureMinisign, "/minisign/legacy", nil},
+		{"minisign of another file", signatureMinisign, "/minisign/other", utils.ErrSignature},
+		{"minisign by another key", signatureMinisign, "/minisign/forged", utils.ErrSignature},
+		{"minisign with another key id", signatureMinisign, "/minisign/stranger", utils.ErrSignature},
+		{"minisign with a tampered comment", signatureMinisign, "/minisign/tampered", utils.ErrSignature},
+		{"garbled minisign", signatureMinisign, "/minisign/garbage", utils.ErrSignature},
+		{"missing minisign", signatureMinisign, "/minisign/missing", utils.ErrSignature},
+		{"armored pgp", signaturePGP, "/pgp/armored", nil},
+		{"binary pgp", signaturePGP, "/pgp/binary", nil},
+		{"pgp of another file", signaturePGP, "/pgp/other", utils.ErrSignature},
+		{"pgp by another key", signaturePGP, "/pgp/forged", utils.ErrSignature},
+		{"garbled pgp", signaturePGP, "/pgp/garbage", utils.ErrSignature},
+		{"missing pgp", signaturePGP, "/pgp/missing", utils.ErrSignature},
+		{"unsupported type", "x509", "/unsupported/anything", utils.ErrSignature},
+	}
+	for _, test := range tests {
+		key := minisign.publicKey()
+		if test.signatureType == signaturePGP {
+			key = pgpPublic
+		}
+		a := artifact{Name: "artifact", SignatureType: test.signatureType, SignatureLink: srv.URL + test.link}
+		e := verifyVendorSignature(context.Background(), vendorConfig(t, key), "demo", a, path)
+		if test.want == nil && e != nil || test.want != nil && !errors.Is(e, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, e, test.want)
+		}
+	}
+}
+
+func TestVerifyVendorSignatureNeedsAPinnedKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "artifact")
+	if e := os.WriteFile(path, []byte("the artifact"), 0644); e != nil {
+		t.Fatal(e)
+	}
+	a := artifact{Name: "artifact", SignatureType: signatureMinisign, SignatureLink: "http://127.0.0.1:1/never-fetched"}
+	// without a key for the package the signature is ignored, since the server named it
+	if e := verifyVendorSignature(context.Background(), vendorConfig(t, nil), "other", a, path); e != nil {
+		t.Errorf("checked a signature without a pinned key: %v", e)
+	}
+	config := vendorConfig(t, nil)
+	config.VendorKeys["demo"] = filepath.Join(t.TempDir(), "missing.pub")
+	if e := verifyVendorSignature(context.Background(), config, "demo", a, path); !errors.Is(e, utils.ErrConfig) {
+		t.Errorf("got %v for a missing key file, want ErrConfig", e)
+	}
+}
@@ -0,0 +1,281 @@
+package candidates
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"rgx/common/log"
+	"rgx/common/utils"
+)
+
+const (
+	// stagingDir is kept in the packages directory, installs are set up there and only moved
+	// into place once they succeeded
+	stagingDir = ".staging"
+	// completeMarker is written into an extracted directory once the install it belongs to
+	// succeeded, a directory without it is left over from an install that failed
+	completeMarker = ".rgx-complete"
+	// staleStaging is how old a staging directory must be before an install removes it, it
+	// was left behind by an rgx process that was killed
+	staleStaging = 24 * time.Hour
+)
+
+// staging is the staging directory of one install. Its packages and rc directories mirror the
+// packages directory and the rc file directory: artifacts are extracted there, steps and the
+// setup script run there, and commit moves the result into place once all of them succeeded.
+type staging struct {
+	config   *utils.RgxConfig
+	dir      string
+	packages string
+	rc       string
+	n        int
+}
+
+func newStaging(config *utils.RgxConfig) (*staging, error) {
+	base := filepath.Join(config.PackagesDir, stagingDir)
+	if e := os.MkdirAll(base, 0775); e != nil {
+		return nil, e
+	}
+	removeStaleStaging(base)
+	dir, e := os.MkdirTemp(base, "install-")
+	if e != nil {
+		return nil, e
+	}
+	s := &staging{config: config, dir: dir, packages: filepath.Join(dir, "packages"), rc: filepath.Join(dir, "rc")}
+	for _, d := range []string{s.packages, s.rc} {
+		if e := os.Mkdir(d, 0775); e != nil {
+			s.remove()
+			return nil, e
+		}
+	}
+	return s, nil
+}
+
+func removeStaleStaging(base string) {
+	entries, _ := os.ReadDir(base)
+	for _, entry := range entries {
+		info, e := entry.Info()
+		if e != nil || time.Since(info.ModTime()) < staleStaging {
+			continue
+		}
+		path := filepath.Join(base, entry.Name())
+		log.Debug("removing %s, left behind by an earlier install", path)
+		if e := os.RemoveAll(path); e != nil {
+			log.Warn("could not remove %s: %s", path, e.Error())
+		}
+	}
+}
+
+// remove deletes the staging directory with whatever was not moved out of it
+func (s *staging) remove() {
+	if s == nil || s.dir == "" {
+		return
+	}
+	if e := os.RemoveAll(s.dir); e != nil {
+		log.Warn("could not remove %s: %s", s.dir, e.Error())
+	}
+	s.dir = ""
+}
+
+// path returns where path, in the packages directory or the rc file directory, is staged
+func (s *staging) path(path string) string {
+	if rel, ok := relativeTo(path, s.config.PackagesDir); ok {
+		return filepath.Join(s.packages, rel)
+	}
+	if filepath.Dir(path) == filepath.Clean(s.config.RcFileDir) {
+		return filepath.Join(s.rc, filepath.Base(path))
+	}
+	return path
+}
+
+// finalPath is the reverse of path, it returns false for paths outside the staging directory
+func (s *staging) finalPath(path string) (string, bool) {
+	if rel, ok := relativeTo(path, s.packages); ok {
+		return filepath.Join(s.config.PackagesDir, rel), true
+	}
+	if filepath.Dir(path) == s.rc {
+		return filepath.Join(s.config.RcFileDir, filepath.Base(path)), true
+	}
+	return "", false
+}
+
+func relativeTo(path, dir string) (string, bool) {
+	if filepath.Clean(path) == filepath.Clean(dir) {
+		return ".", true
+	}
+	if !isInside(path, dir) {
+		return "", false
+	}
+	rel, e := filepath.Rel(filepath.Clean(dir), filepath.Clean(path))
+	return rel, e == nil
+}
+
+// commit moves what was staged into the packages directory and the rc file directory,
+// merging directories that exist there already. If that fails part way, what it moved to a
+// place that did not exist before is removed again.
+func (s *staging) commit() (err error) {
+	var created []string
+	defer func() {
+		if err != nil {
+			for i := len(created) - 1; i >= 0; i-- {
+				_ = os.RemoveAll(created[i])
+			}
+		}
+	}()
+	for _, dirs := range [][2]string{{s.packages, s.config.PackagesDir}, {s.rc, s.config.RcFileDir}} {
+		entries, e := os.ReadDir(dirs[0])
+		if e != nil {
+			return e
+		}
+		if len(entries) > 0 {
+			if e := os.MkdirAll(dirs[1], 0775); e != nil {
+				return e
+			}
+		}
+		for _, entry := range entries {
+			src, dst := filepath.Join(dirs[0], entry.Name()), filepath.Join(dirs[1], entry.Name())
+			if e := moveInto(src, dst, &created); e != nil {
+				return fmt.Errorf("could not move %s to %s: %w", src, dst, e)
+			}
+			log.Debug("moved %s to %s", src, dst)
+		}
+	}
+	return nil
+}
+
+// unstageRcFiles rewrites the staged paths a setup script wrote into rc files to the paths
+// they are moved to, since the script only sees the staging directory
+func (s *staging) unstageRcFiles() error {
+	entries, e := os.ReadDir(s.rc)
+	if e != nil {
+		return e
+	}
+	replacer := strings.NewReplacer(
+		s.packages, s.config.PackagesDir,
+		s.rc, s.config.RcFileDir,
+		msysPath(s.packages), msysPath(s.config.PackagesDir),
+	)
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		path := filepath.Join(s.rc, entry.Name())
+		b, e := os.ReadFile(path)
+		if e != nil {
+			return e
+		}
+		if rewritten := replacer.Replace(string(b)); rewritten != string(b) {
+			if e := os.WriteFile(path, []byte(rewritten), 0664); e != nil {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// extract extracts the archive of a and stages its extract_target. If an earlier artifact of
+// the same install staged something there already, the two are merged.
+func (s *staging) extract(ctx context.Context, a artifact, archive, extractdir, targetdir string) error {
+	rel, e := filepath.Rel(extractdir, targetdir)
+	if e != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return fmt.Errorf("%w: extract_target %s of %s is not inside extract_dir %s", utils.ErrExtract, a.ExtractTarget, a.Name, a.ExtractDir)
+	}
+	s.n++
+	stage := filepath.Join(s.dir, fmt.Sprint(s.n))
+	log.Info("extracting files to %s", extractdir)
+	if e := utils.Extract(ctx, archive, stage, a.extractOptions()); e != nil {
+		return e
+	}
+	extracted := filepath.Join(stage, rel)
+	if !utils.Exists(extracted) {
+		return fmt.Errorf("%w: %s does not contain %s", utils.ErrExtract, a.Name, rel)
+	}
+	staged := s.path(targetdir)
+	if e := os.MkdirAll(filepath.Dir(staged), 0775); e != nil {
+		return e
+	}
+	if e := moveInto(extracted, staged, nil); e != nil {
+		return fmt.Errorf("could not move %s to %s: %w", extracted, staged, e)
+	}
+	return nil
+}
+
+// copy stages the downloaded file as targetfile
+func (s *staging) copy(source, targetfile string) error {
+	staged := s.path(targetfile)
+	if e := os.MkdirAll(filepath.Dir(staged), 0775); e != nil {
+		return e
+	}
+	_, e := utils.Copy(source, staged)
+	return e
+}
+
+// moveInto renames src to dst, or if dst is a directory already, moves the contents of src
+// into it. If created is not nil, the paths that did not exist before are added to it.
+func moveInto(src, dst string, created *[]string) error {
+	info, e := os.Lstat(dst)
+	if os.IsNotExist(e) {
+		if e := os.Rename(src, dst); e != nil {
+			return e
+		}
+		if created != nil {
+			*created = append(*created, dst)
+		}
+		return nil
+	}
+	if e != nil {
+		return e
+	}
+	srcInfo, e := os.Lstat(src)
+	if e != nil {
+		return e
+	}
+	if !info.IsDir() || !srcInfo.IsDir() {
+		if e := os.Remove(dst); e != nil {
+			return e
+		}
+		return os.Rename(src, dst)
+	}
+	entries, e := os.ReadDir(src)
+	if e != nil {
+		return e
+	}
+	for _, entry := range entries {
+		if e := moveInto(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name()), created); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// isComplete reports whether targetdir holds a finished install. Directories installed
+// before completion markers were written count as complete if the registry lists them.
+func isComplete(reg Registry, targetdir string) bool {
+	if utils.Exists(filepath.Join(targetdir, completeMarker)) {
+		return true
+	}
+	if !utils.Exists(targetdir) {
+		return false
+	}
+	for _, p := range reg.Packages {
+		for _, t := range p.Targets() {
+			if filepath.Clean(t) == filepath.Clean(targetdir) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func markComplete(p InstalledPackage, targetdir string) error {
+	marker := filepath.Join(targetdir, completeMarker)
+	content := fmt.Sprintf("%s %s\n", p.Package, p.PackageVersion)
+	if e := os.WriteFile(marker, []byte(content), 0664); e != nil {
+		return fmt.Errorf("could not write %s: %w", marker, e)
+	}
+	return nil
+}
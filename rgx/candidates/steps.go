@@ -0,0 +1,513 @@
+package candidates
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"rgx/common/log"
+	"rgx/common/utils"
+)
+
+// Steps of a recipe run natively after its artifacts are staged and before its setup script,
+// so that the common cases need no script per platform. Their paths are relative to the
+// packages directory like extract_target, use / on every platform, and are templates:
+// {{.Package}}, {{.MajorVersion}}, {{.Version}}, {{.PackagesDir}}, {{.RcFileDir}} and
+// {{.Target}}, the install location of the first artifact. A step may only write inside the
+// packages directory and, for write_env, the rc file directory. Steps work on the staging
+// directory of the install, but templates expand to the final paths, since that is where
+// links and rc files point once the install is moved into place.
+type step struct {
+	// Action is one of rename, symlink, chmod, write_env, mkdir, delete and template_file
+	Action string `json:"action"`
+	Path   string `json:"path,omitempty"`
+	// From is what rename moves to Path and what the symlink at Path points to
+	From string `json:"from,omitempty"`
+	// Mode is an octal mode such as "0755" or "1777" for chmod, mkdir and template_file
+	Mode string `json:"mode,omitempty"`
+	// Env and PathEntries are what write_env sets up. Path is then the name of the rc file for
+	// sh, by default .<package>-<major version>-rc; on windows the file for cmd.exe is named
+	// after it with .cmd appended, by default use-<package>-<major version>.cmd.
+	Env         map[string]string `json:"env,omitempty"`
+	PathEntries []string          `json:"path_entries,omitempty"`
+	// Content is the template template_file writes to Path
+	Content string `json:"content,omitempty"`
+}
+
+// envKey is what write_env accepts as the name of a variable, anything else could inject
+// commands into the rc file
+var envKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type stepVars struct {
+	Package      string
+	MajorVersion string
+	Version      string
+	PackagesDir  string
+	RcFileDir    string
+	Target       string
+}
+
+// stepRunner runs the steps of one install and records what they create
+type stepRunner struct {
+	vars    stepVars
+	stage   *staging
+	targets []string
+	// existing are the targets an earlier install set up, steps inside them ran back then
+	existing []string
+	created  []string
+}
+
+// runSteps records in created the files and directories the steps created outside the install
+// locations of the artifacts, so that they can be removed on uninstall. Steps inside the
+// targets in existing are skipped.
+func runSteps(pkg, majorVersion string, r recipe, stage *staging, installed []InstalledArtifact, existing []string) (*stepRunner, error) {
+	sr := &stepRunner{vars: stepVars{
+		Package:      pkg,
+		MajorVersion: majorVersion,
+		Version:      r.PackageVersion,
+		PackagesDir:  stage.config.PackagesDir,
+		RcFileDir:    stage.config.RcFileDir,
+	}, stage: stage, existing: existing}
+	for _, a := range installed {
+		if a.Target != "" {
+			sr.targets = append(sr.targets, a.Target)
+		}
+	}
+	if len(sr.targets) > 0 {
+		sr.vars.Target = sr.targets[0]
+	}
+
+	for i, s := range r.Steps {
+		if sr.alreadyDone(s) {
+			log.Debug("skipping step %d of %s (%s), its target was set up by an earlier install", i+1, pkg, s.Action)
+			continue
+		}
+		log.Debug("running step %d of %s: %s", i+1, pkg, s.Action)
+		if e := sr.run(s); e != nil {
+			return nil, fmt.Errorf("step %d (%s) of %s %s: %w", i+1, s.Action, pkg, r.PackageVersion, e)
+		}
+	}
+	return sr, nil
+}
+
+func (sr *stepRunner) alreadyDone(s step) bool {
+	paths := []string{s.Path, s.From}
+	switch s.Action {
+	case "write_env":
+		return false
+	case "symlink":
+		// only the link is written, what it points to may well be in an earlier install
+		paths = paths[:1]
+	}
+	for _, p := range paths {
+		if p == "" {
+			continue
+		}
+		path, e := sr.path(p)
+		if e != nil {
+			// run reports it
+			return false
+		}
+		for _, t := range sr.existing {
+			if path == t || isInside(path, t) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (sr *stepRunner) run(s step) error {
+	switch s.Action {
+	case "rename":
+		from, e := sr.path(s.From)
+		if e != nil {
+			return e
+		}
+		to, e := sr.path(s.Path)
+		if e != nil {
+			return e
+		}
+		if e := sr.mkdirFor(to); e != nil {
+			return e
+		}
+		sr.record(to)
+		return stepError(os.Rename(sr.stage.path(from), sr.stage.path(to)))
+	case "symlink":
+		link, e := sr.path(s.Path)
+		if e != nil {
+			return e
+		}
+		target, e := sr.path(s.From)
+		if e != nil {
+			return e
+		}
+		return sr.symlink(target, link)
+	case "chmod":
+		path, e := sr.path(s.Path)
+		if e != nil {
+			return e
+		}
+		mode, e := parseMode(s.Mode, 0)
+		if e != nil {
+			return e
+		}
+		if utils.PlatformOS() == "windows" {
+			log.Debug("not changing the mode of %s on windows", path)
+			return nil
+		}
+		return stepError(os.Chmod(sr.stage.path(path), mode))
+	case "mkdir":
+		path, e := sr.path(s.Path)
+		if e != nil {
+			return e
+		}
+		mode, e := parseMode(s.Mode, 0775)
+		if e != nil {
+			return e
+		}
+		if e := sr.mkdirFor(path); e != nil {
+			return e
+		}
+		sr.record(path)
+		return stepError(os.MkdirAll(sr.stage.path(path), mode))
+	case "delete":
+		path, e := sr.path(s.Path)
+		if e != nil {
+			return e
+		}
+		return stepError(os.RemoveAll(sr.stage.path(path)))
+	case "template_file":
+		path, e := sr.path(s.Path)
+		if e != nil {
+			return e
+		}
+		mode, e := parseMode(s.Mode, 0664)
+		if e != nil {
+			return e
+		}
+		content, e := sr.expand(s.Content)
+		if e != nil {
+			return e
+		}
+		return sr.writeFile(path, content, mode)
+	case "write_env":
+		return sr.writeEnv(s)
+	}
+	return fmt.Errorf("%w: unknown action %q", utils.ErrScript, s.Action)
+}
+
+func stepError(e error) error {
+	if e != nil {
+		return fmt.Errorf("%w: %w", utils.ErrScript, e)
+	}
+	return nil
+}
+
+func (sr *stepRunner) expand(s string) (string, error) {
+	t, e := template.New("step").Option("missingkey=error").Parse(s)
+	if e != nil {
+		return "", fmt.Errorf("%w: %s", utils.ErrScript, e.Error())
+	}
+	var b strings.Builder
+	if e := t.Execute(&b, sr.vars); e != nil {
+		return "", fmt.Errorf("%w: %s", utils.ErrScript, e.Error())
+	}
+	return b.String(), nil
+}
+
+// path expands p and resolves it against the packages directory, refusing paths outside it
+func (sr *stepRunner) path(p string) (string, error) {
+	if p == "" {
+		return "", fmt.Errorf("%w: the step has no path", utils.ErrScript)
+	}
+	expanded, e := sr.expand(p)
+	if e != nil {
+		return "", e
+	}
+	path := filepath.FromSlash(expanded)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(sr.stage.config.PackagesDir, path)
+	}
+	path = filepath.Clean(path)
+	if !isInside(path, sr.stage.config.PackagesDir) {
+		return "", fmt.Errorf("%w: %s is outside %s", ErrUnsafePath, path, sr.stage.config.PackagesDir)
+	}
+	return path, nil
+}
+
+// record remembers path as created by the steps, unless it exists already or an artifact
+// installed it, since uninstall removes those anyway
+func (sr *stepRunner) record(path string) {
+	if sr.exists(path) {
+		return
+	}
+	sr.own(path)
+}
+
+// exists reports whether path exists in place or was staged before the steps ran
+func (sr *stepRunner) exists(path string) bool {
+	for _, p := range []string{path, sr.stage.path(path)} {
+		if _, e := os.Lstat(p); e == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// own records path as created by the steps even if it exists already, unless it is inside
+// something the install owns anyway
+func (sr *stepRunner) own(path string) {
+	for _, t := range slices.Concat(sr.targets, sr.created) {
+		if path == t || isInside(path, t) {
+			return
+		}
+	}
+	sr.created = append(sr.created, path)
+}
+
+// mkdirFor stages the parent directories of path, recording the topmost one it creates. The
+// rc file directory is shared by all packages, so it is never recorded.
+func (sr *stepRunner) mkdirFor(path string) error {
+	dir := filepath.Dir(path)
+	if dir != filepath.Clean(sr.stage.config.RcFileDir) {
+		top := dir
+		for parent := filepath.Dir(top); parent != top && !sr.exists(parent); parent = filepath.Dir(top) {
+			top = parent
+		}
+		sr.record(top)
+	}
+	return stepError(os.MkdirAll(sr.stage.path(dir), 0775))
+}
+
+func (sr *stepRunner) writeFile(path, content string, mode os.FileMode) error {
+	if e := sr.mkdirFor(path); e != nil {
+		return e
+	}
+	sr.record(path)
+	return stepError(os.WriteFile(sr.stage.path(path), []byte(content), mode))
+}
+
+// symlink creates link pointing to target, relative to the directory of link so that the
+// packages directory can be moved. Windows only lets administrators and developer mode
+// create symlinks, so a file is copied there instead if that fails.
+func (sr *stepRunner) symlink(target, link string) error {
+	rel, e := filepath.Rel(filepath.Dir(link), target)
+	if e != nil {
+		return stepError(e)
+	}
+	if e := sr.mkdirFor(link); e != nil {
+		return e
+	}
+	staged := sr.stage.path(link)
+	for _, l := range []string{link, staged} {
+		if info, e := os.Lstat(l); e == nil && info.Mode()&os.ModeSymlink == 0 {
+			return fmt.Errorf("%w: %s exists and is not a link", ErrUnsafePath, link)
+		}
+	}
+	if e := os.Remove(staged); e != nil && !os.IsNotExist(e) {
+		return stepError(e)
+	}
+	sr.record(link)
+	e = os.Symlink(rel, staged)
+	if e != nil && utils.PlatformOS() == "windows" {
+		source := sr.stage.path(target)
+		if !utils.Exists(source) {
+			source = target
+		}
+		if info, statErr := os.Stat(source); statErr == nil && !info.IsDir() {
+			log.Debug("could not create the symlink %s, copying %s instead: %s", link, target, e.Error())
+			return stepError(copyFile(source, staged, info.Mode()))
+		}
+	}
+	return stepError(e)
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	b, e := os.ReadFile(src)
+	if e != nil {
+		return e
+	}
+	return os.WriteFile(dst, b, mode)
+}
+
+// writeEnv writes an rc file that sets the step's variables and puts its PATH entries first.
+// On windows it writes a .cmd file for cmd.exe and the rc file for git bash.
+func (sr *stepRunner) writeEnv(s step) error {
+	keys := make([]string, 0, len(s.Env))
+	for k := range s.Env {
+		if !envKey.MatchString(k) {
+			return fmt.Errorf("%w: %q is not a valid variable name", utils.ErrScript, k)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	values := make(map[string]string, len(s.Env))
+	for _, k := range keys {
+		v, e := sr.expandLine(s.Env[k])
+		if e != nil {
+			return e
+		}
+		values[k] = nativePath(v)
+	}
+	var entries []string
+	for _, p := range s.PathEntries {
+		v, e := sr.expandLine(p)
+		if e != nil {
+			return e
+		}
+		entries = append(entries, filepath.FromSlash(v))
+	}
+
+	name := rcFileName(sr.vars.Package, sr.vars.MajorVersion)
+	var sh strings.Builder
+	_, _ = fmt.Fprintf(&sh, "# written by rgx for %s %s\n", sr.vars.Package, sr.vars.Version)
+	for _, k := range keys {
+		v := values[k]
+		if filepath.IsAbs(v) {
+			v = msysPath(v)
+		}
+		_, _ = fmt.Fprintf(&sh, "export %s=%s\n", k, shellQuote(v))
+	}
+	if len(entries) > 0 {
+		var quoted []string
+		for _, p := range entries {
+			quoted = append(quoted, shellQuote(msysPath(p)))
+		}
+		_, _ = fmt.Fprintf(&sh, "export PATH=%s:\"$PATH\"\n", strings.Join(quoted, ":"))
+	}
+
+	cmdName := cmdFileName(sr.vars.Package, sr.vars.MajorVersion)
+	if s.Path != "" {
+		name, cmdName = s.Path, s.Path+".cmd"
+	}
+	if utils.PlatformOS() != "windows" {
+		return sr.writeRcFile(name, sh.String(), true)
+	}
+
+	var cmd strings.Builder
+	cmd.WriteString("@echo off\r\n")
+	for _, k := range keys {
+		_, _ = fmt.Fprintf(&cmd, "set \"%s=%s\"\r\n", k, cmdEscape(values[k]))
+	}
+	if len(entries) > 0 {
+		_, _ = fmt.Fprintf(&cmd, "set \"PATH=%s;%%PATH%%\"\r\n", cmdEscape(strings.Join(entries, ";")))
+	}
+	if e := sr.writeRcFile(name, sh.String(), false); e != nil {
+		return e
+	}
+	return sr.writeRcFile(cmdName, cmd.String(), true)
+}
+
+// expandLine expands a value for an rc file, which must stay on one line
+func (sr *stepRunner) expandLine(s string) (string, error) {
+	v, e := sr.expand(s)
+	if e != nil {
+		return "", e
+	}
+	if strings.ContainsAny(v, "\r\n") {
+		return "", fmt.Errorf("%w: %q spans more than one line", utils.ErrScript, v)
+	}
+	return v, nil
+}
+
+// shellQuote quotes v for sh, where nothing inside single quotes is special
+func shellQuote(v string) string {
+	return "'" + strings.ReplaceAll(v, "'", `'\''`) + "'"
+}
+
+// cmdEscape escapes v for the inside of set "name=value" in a batch file. % is expanded even
+// in quotes, the other special characters only where a " in v has ended the quoting; set
+// takes everything up to the last quote as the value, so those quotes stay part of it.
+func cmdEscape(v string) string {
+	var b strings.Builder
+	quoted := true
+	for _, c := range v {
+		switch {
+		case c == '%':
+			b.WriteString("%%")
+			continue
+		case c == '"':
+			quoted = !quoted
+		case !quoted && strings.ContainsRune("^&|<>()", c):
+			b.WriteByte('^')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func (sr *stepRunner) writeRcFile(name, content string, announce bool) error {
+	expanded, e := sr.expand(name)
+	if e != nil {
+		return e
+	}
+	if expanded != filepath.Base(expanded) {
+		return fmt.Errorf("%w: the rc file %q must be a file name", ErrUnsafePath, expanded)
+	}
+	path := filepath.Join(sr.stage.config.RcFileDir, expanded)
+	// rc files are per package, so one rewritten here is removed on uninstall unless another
+	// installed package claims it too
+	sr.own(path)
+	if e := sr.writeFile(path, content, 0664); e != nil {
+		return e
+	}
+	if announce {
+		log.Info("to use %s %s, run: %s", sr.vars.Package, sr.vars.Version, useCommand(path))
+	}
+	return nil
+}
+
+// rcFileName and cmdFileName are the files in the rc file directory that set up the
+// environment of a package, written by write_env or a setup script
+func rcFileName(pkg, majorVersion string) string {
+	return fmt.Sprintf(".%s-%s-rc", pkg, majorVersion)
+}
+
+func cmdFileName(pkg, majorVersion string) string {
+	return fmt.Sprintf("use-%s-%s.cmd", pkg, majorVersion)
+}
+
+func useCommand(rcFile string) string {
+	if strings.HasSuffix(rcFile, ".cmd") {
+		return rcFile
+	}
+	return "source " + msysPath(rcFile)
+}
+
+// nativePath gives values that are absolute paths the separators of the platform, and leaves
+// everything else alone
+func nativePath(v string) string {
+	if p := filepath.FromSlash(v); filepath.IsAbs(p) {
+		return p
+	}
+	return v
+}
+
+// parseMode reads an octal mode. The setuid, setgid and sticky bits become their os.FileMode
+// flags, which sit elsewhere than in a unix mode.
+func parseMode(s string, fallback os.FileMode) (os.FileMode, error) {
+	if s == "" {
+		if fallback == 0 {
+			return 0, fmt.Errorf("%w: the step has no mode", utils.ErrScript)
+		}
+		return fallback, nil
+	}
+	m, e := strconv.ParseUint(s, 8, 32)
+	if e != nil || m > 0o7777 {
+		return 0, fmt.Errorf("%w: invalid mode %q", utils.ErrScript, s)
+	}
+	mode := os.FileMode(m & 0o777)
+	for bit, flag := range map[uint64]os.FileMode{0o4000: os.ModeSetuid, 0o2000: os.ModeSetgid, 0o1000: os.ModeSticky} {
+		if m&bit != 0 {
+			mode |= flag
+		}
+	}
+	return mode, nil
+}
@@ -0,0 +1,267 @@
+package candidates
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+
+	"rgx/common/utils"
+)
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", "''"},
+		{"/opt/go", "'/opt/go'"},
+		{"it's", `'it'\''s'`},
+		{"$HOME", "'$HOME'"},
+		{"`id`", "'`id`'"},
+		{`a "b" c`, `'a "b" c'`},
+		{`back\slash`, `'back\slash'`},
+	}
+	for _, test := range tests {
+		if got := shellQuote(test.in); got != test.want {
+			t.Errorf("shellQuote(%q) = %s, want %s", test.in, got, test.want)
+		}
+	}
+}
+
+// TestShellQuoteRoundTrips has sh read back what shellQuote quoted
+func TestShellQuoteRoundTrips(t *testing.T) {
+	sh, e := exec.LookPath("sh")
+	if e != nil || runtime.GOOS == "windows" {
+		t.Skip("no sh")
+	}
+	for _, v := range []string{"plain", "it's", "$HOME", "`id`", "$(id)", `"; rm -rf / #`, `\n`, "a b\tc", "!x"} {
+		out, e := exec.Command(sh, "-c", "V="+shellQuote(v)+"; printf %s \"$V\"").Output()
+		if e != nil {
+			t.Fatalf("sh failed for %q: %s", v, e)
+		}
+		if string(out) != v {
+			t.Errorf("sh read %q back as %q", v, out)
+		}
+	}
+}
+
+func TestCmdEscape(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`C:\Program Files\Go`, `C:\Program Files\Go`},
+		{"100%", "100%%"},
+		{"%PATH%", "%%PATH%%"},
+		{"a^b&c", "a^b&c"},
+		{`a"b&c"d`, `a"b^&c"d`},
+		{`x"^|<>()`, `x"^^^|^<^>^(^)`},
+		{`"%"`, `"%%"`},
+	}
+	for _, test := range tests {
+		if got := cmdEscape(test.in); got != test.want {
+			t.Errorf("cmdEscape(%q) = %s, want %s", test.in, got, test.want)
+		}
+	}
+}
+
+func TestEnvKey(t *testing.T) {
+	for _, k := range []string{"GOROOT", "_x", "A1_B2"} {
+		if !envKey.MatchString(k) {
+			t.Errorf("%q was rejected", k)
+		}
+	}
+	for _, k := range []string{"", "1A", "A-B", "A B", "A=B", "$A", "A;rm", "A\nB", "Ä"} {
+		if envKey.MatchString(k) {
+			t.Errorf("%q was accepted", k)
+		}
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		in       string
+		fallback os.FileMode
+		want     os.FileMode
+		fails    bool
+	}{
+		{"0755", 0, 0o755, false},
+		{"644", 0, 0o644, false},
+		{"", 0o664, 0o664, false},
+		{"", 0, 0, true},
+		{"4755", 0, os.ModeSetuid | 0o755, false},
+		{"2775", 0, os.ModeSetgid | 0o775, false},
+		{"1777", 0, os.ModeSticky | 0o777, false},
+		{"10000", 0, 0, true},
+		{"0789", 0, 0, true},
+		{"rwx", 0, 0, true},
+		{"-1", 0, 0, true},
+	}
+	for _, test := range tests {
+		got, e := parseMode(test.in, test.fallback)
+		if test.fails {
+			if !errors.Is(e, utils.ErrScript) {
+				t.Errorf("parseMode(%q) = %v, %v, want ErrScript", test.in, got, e)
+			}
+			continue
+		}
+		if e != nil || got != test.want {
+			t.Errorf("parseMode(%q) = %v, %v, want %v", test.in, got, e, test.want)
+		}
+	}
+}
+
+// stepsConfig is a packages directory with demo-1.0 staged as the install of an artifact
+func stepsConfig(t *testing.T) (*staging, []InstalledArtifact) {
+	t.Helper()
+	dir := t.TempDir()
+	config := &utils.RgxConfig{PackagesDir: filepath.Join(dir, "packages"), RcFileDir: filepath.Join(dir, "rc")}
+	stage, e := newStaging(config)
+	if e != nil {
+		t.Fatal(e)
+	}
+	t.Cleanup(stage.remove)
+	target := filepath.Join(config.PackagesDir, "demo-1.0")
+	for name, content := range map[string]string{"tool": "#!/bin/sh\n", "junk/file": "x", "keep": "k"} {
+		path := stage.path(filepath.Join(target, filepath.FromSlash(name)))
+		if e := os.MkdirAll(filepath.Dir(path), 0775); e != nil {
+			t.Fatal(e)
+		}
+		if e := os.WriteFile(path, []byte(content), 0644); e != nil {
+			t.Fatal(e)
+		}
+	}
+	return stage, []InstalledArtifact{{Name: "demo", Target: target}}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, e := os.ReadFile(path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return string(b)
+}
+
+func TestRunSteps(t *testing.T) {
+	stage, installed := stepsConfig(t)
+	config := stage.config
+	r := recipe{PackageVersion: "1.0.3", Steps: []step{
+		{Action: "mkdir", Path: "demo/bin", Mode: "0755"},
+		{Action: "rename", From: "{{.Target}}/tool", Path: "demo/bin/tool"},
+		{Action: "chmod", Path: "demo/bin/tool", Mode: "0750"},
+		{Action: "symlink", From: "demo-1.0/keep", Path: "demo/keep"},
+		{Action: "delete", Path: "demo-1.0/junk"},
+		{Action: "template_file", Path: "demo/etc/VERSION", Content: "{{.Package}} {{.Version}} {{.MajorVersion}}"},
+		{Action: "write_env", Env: map[string]string{"DEMO_HOME": "{{.Target}}", "DEMO_NOTE": "it's $HOME"},
+			PathEntries: []string{"{{.PackagesDir}}/demo/bin"}},
+		{Action: "write_env", Path: ".demo-extra", Env: map[string]string{"EXTRA": "1"}},
+	}}
+	sr, e := runSteps("demo", "1", r, stage, installed, nil)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e := stage.commit(); e != nil {
+		t.Fatal(e)
+	}
+
+	pk := func(p string) string { return filepath.Join(config.PackagesDir, filepath.FromSlash(p)) }
+	if got := readFile(t, pk("demo/bin/tool")); got != "#!/bin/sh\n" {
+		t.Errorf("the renamed tool contains %q", got)
+	}
+	if utils.Exists(pk("demo-1.0/tool")) || utils.Exists(pk("demo-1.0/junk")) {
+		t.Error("rename or delete left their source behind")
+	}
+	if got := readFile(t, pk("demo/keep")); got != "k" {
+		t.Errorf("the symlink reads %q", got)
+	}
+	if got := readFile(t, pk("demo/etc/VERSION")); got != "demo 1.0.3 1" {
+		t.Errorf("the template wrote %q", got)
+	}
+	if runtime.GOOS != "windows" {
+		if info, e := os.Stat(pk("demo/bin/tool")); e != nil || info.Mode().Perm() != 0o750 {
+			t.Errorf("the tool has mode %v, %v", info.Mode(), e)
+		}
+		if info, e := os.Stat(pk("demo/bin")); e != nil || info.Mode().Perm() != 0o755 {
+			t.Errorf("the bin directory has mode %v, %v", info.Mode(), e)
+		}
+		if link, e := os.Readlink(pk("demo/keep")); e != nil || link != filepath.Join("..", "demo-1.0", "keep") {
+			t.Errorf("the symlink points to %q, %v", link, e)
+		}
+	}
+
+	rc := readFile(t, filepath.Join(config.RcFileDir, rcFileName("demo", "1")))
+	for _, line := range []string{
+		"export DEMO_HOME=" + shellQuote(msysPath(pk("demo-1.0"))),
+		"export DEMO_NOTE='it'\\''s $HOME'",
+		"export PATH=" + shellQuote(msysPath(pk("demo/bin"))) + ":\"$PATH\"",
+	} {
+		if !strings.Contains(rc, line+"\n") {
+			t.Errorf("the rc file lacks %s:\n%s", line, rc)
+		}
+	}
+	if got := readFile(t, filepath.Join(config.RcFileDir, ".demo-extra")); !strings.Contains(got, "export EXTRA='1'\n") {
+		t.Errorf("the rc file named by path contains:\n%s", got)
+	}
+	if runtime.GOOS == "windows" {
+		for _, name := range []string{cmdFileName("demo", "1"), ".demo-extra.cmd"} {
+			if !utils.Exists(filepath.Join(config.RcFileDir, name)) {
+				t.Errorf("%s was not written", name)
+			}
+		}
+	}
+
+	// the artifact's target is removed on uninstall anyway
+	want := []string{pk("demo"), filepath.Join(config.RcFileDir, rcFileName("demo", "1")), filepath.Join(config.RcFileDir, ".demo-extra")}
+	if runtime.GOOS == "windows" {
+		want = append(want, filepath.Join(config.RcFileDir, cmdFileName("demo", "1")), filepath.Join(config.RcFileDir, ".demo-extra.cmd"))
+	}
+	slices.Sort(want)
+	got := slices.Clone(sr.created)
+	slices.Sort(got)
+	if !slices.Equal(got, want) {
+		t.Errorf("created %q, want %q", got, want)
+	}
+}
+
+func TestRunStepsSkipsExistingTargets(t *testing.T) {
+	stage, installed := stepsConfig(t)
+	r := recipe{PackageVersion: "1.0.3", Steps: []step{{Action: "delete", Path: "demo-1.0/keep"}}}
+	if _, e := runSteps("demo", "1", r, stage, installed, []string{installed[0].Target}); e != nil {
+		t.Fatal(e)
+	}
+	if !utils.Exists(stage.path(filepath.Join(installed[0].Target, "keep"))) {
+		t.Error("a step inside an existing target ran")
+	}
+}
+
+func TestRunStepsRejectsInvalidSteps(t *testing.T) {
+	tests := []struct {
+		name string
+		step step
+		want error
+	}{
+		{"outside the packages directory", step{Action: "mkdir", Path: "../outside"}, ErrUnsafePath},
+		{"absolute path outside", step{Action: "template_file", Path: "/etc/rgx-test"}, ErrUnsafePath},
+		{"rename from outside", step{Action: "rename", From: "../../x", Path: "demo/x"}, ErrUnsafePath},
+		{"rc file outside the rc directory", step{Action: "write_env", Path: "../.profile", Env: map[string]string{"A": "1"}}, ErrUnsafePath},
+		{"unknown action", step{Action: "download", Path: "demo"}, utils.ErrScript},
+		{"missing path", step{Action: "delete"}, utils.ErrScript},
+		{"chmod without a mode", step{Action: "chmod", Path: "demo-1.0/keep"}, utils.ErrScript},
+		{"invalid mode", step{Action: "mkdir", Path: "demo", Mode: "99999"}, utils.ErrScript},
+		{"unknown template variable", step{Action: "mkdir", Path: "{{.Nope}}"}, utils.ErrScript},
+		{"invalid variable name", step{Action: "write_env", Env: map[string]string{"A;id": "1"}}, utils.ErrScript},
+		{"multi-line value", step{Action: "write_env", Env: map[string]string{"A": "1\nid"}}, utils.ErrScript},
+		{"multi-line path entry", step{Action: "write_env", PathEntries: []string{"bin\r\nid"}}, utils.ErrScript},
+	}
+	for _, test := range tests {
+		stage, installed := stepsConfig(t)
+		_, e := runSteps("demo", "1", recipe{PackageVersion: "1.0.3", Steps: []step{test.step}}, stage, installed, nil)
+		if !errors.Is(e, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, e, test.want)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package candidates
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"rgx/common/log"
+	"rgx/common/utils"
+)
+
+// Uninstall removes an install of pkg and returns the paths it removed, or with dryRun only
+// returns the paths it would remove
+func Uninstall(config *utils.RgxConfig, pkg, version string, dryRun bool) ([]string, error) {
+	reg, e := ReadRegistry(config)
+	if e != nil {
+		return nil, e
+	}
+	found := reg.Find(pkg, version)
+	if len(found) == 0 {
+		return nil, fmt.Errorf("%w: %s %s", ErrNotInstalled, pkg, version)
+	}
+	if len(found) > 1 {
+		var versions []string
+		for _, p := range found {
+			versions = append(versions, p.PackageVersion)
+		}
+		return nil, fmt.Errorf("more than one version of %s matches %s (%s), please specify one",
+			pkg, version, strings.Join(versions, ", "))
+	}
+	p := found[0]
+
+	paths := installedPaths(reg, p)
+	for _, path := range paths {
+		if !isRemovable(config, path) {
+			return nil, fmt.Errorf("%w: refusing to remove %s: it is outside %s and %s",
+				ErrUnsafePath, path, config.PackagesDir, config.RcFileDir)
+		}
+	}
+
+	var removed []string
+	for _, path := range paths {
+		// Lstat, since a link may dangle once what it points to is removed
+		if _, e := os.Lstat(path); os.IsNotExist(e) {
+			log.Debug("already removed: %s", path)
+			continue
+		}
+		if !dryRun {
+			log.Info("removing %s", path)
+			if e := os.RemoveAll(path); e != nil {
+				return removed, fmt.Errorf("could not remove %s: %w", path, e)
+			}
+		}
+		removed = append(removed, path)
+	}
+
+	if dryRun {
+		return removed, nil
+	}
+	if e = deactivate(config, &reg, p); e != nil {
+		return removed, e
+	}
+	reg.Remove(p)
+	if e = reg.Save(); e != nil {
+		return removed, e
+	}
+	if e = RegenerateShims(config); e != nil {
+		return removed, e
+	}
+	log.Info("uninstalled %s %s", p.Package, p.PackageVersion)
+	return removed, nil
+}
+
+// installedPaths returns everything that was written by the install of p, leaving out
+// anything that another installed package also claims
+func installedPaths(reg Registry, p InstalledPackage) []string {
+	shared := make(map[string]bool)
+	for _, x := range reg.Packages {
+		if x.Package == p.Package && x.PackageVersion == p.PackageVersion {
+			continue
+		}
+		for _, path := range ownedPaths(x) {
+			shared[path] = true
+		}
+	}
+
+	var paths []string
+	for _, path := range ownedPaths(p) {
+		if shared[path] {
+			log.Debug("not removing %s, it is used by another installed package", path)
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func ownedPaths(p InstalledPackage) []string {
+	var paths []string
+	for _, a := range p.Artifacts {
+		if a.Target != "" {
+			paths = append(paths, filepath.Clean(a.Target))
+		}
+	}
+	for _, f := range slices.Concat(p.ScriptFiles, p.StepFiles) {
+		paths = append(paths, filepath.Clean(f))
+	}
+	return paths
+}
+
+func isRemovable(config *utils.RgxConfig, path string) bool {
+	return isInside(path, config.PackagesDir) || isInside(path, config.RcFileDir)
+}
+
+// isInside is true if path is strictly below dir, never if it is dir itself
+func isInside(path, dir string) bool {
+	if dir == "" || !filepath.IsAbs(path) {
+		return false
+	}
+	rel, e := filepath.Rel(filepath.Clean(dir), filepath.Clean(path))
+	if e != nil {
+		return false
+	}
+	return rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
+
+// scriptOutputs tracks what a setup script writes while it runs in the staging directory: new
+// entries in its script directory, the rc files of the package, and the files the script
+// declares by appending their paths to the file $RGX_SCRIPT_OUTPUTS names, one per line. Only
+// new entries in the script directory are attributed to the script, since it is shared with
+// other versions of the package. Paths are recorded where they end up once the install is
+// moved into place.
+type scriptOutputs struct {
+	stage     *staging
+	scriptDir string
+	rcFiles   []string
+	// declared is the file the script lists further outputs in
+	declared string
+	before   map[string]time.Time
+	// rcDirBefore are the names in the rc file directory before the install, a file there
+	// that the script declares is only attributed to it if it is new
+	rcDirBefore map[string]bool
+}
+
+func snapshotScriptOutputs(pkg, majorVersion string, stage *staging, scriptDir string) (*scriptOutputs, error) {
+	f, e := os.CreateTemp("", "rgx-outputs-*")
+	if e != nil {
+		return nil, e
+	}
+	_ = f.Close()
+	o := &scriptOutputs{
+		stage:     stage,
+		scriptDir: scriptDir,
+		rcFiles: []string{
+			filepath.Join(stage.config.RcFileDir, rcFileName(pkg, majorVersion)),
+			filepath.Join(stage.config.RcFileDir, cmdFileName(pkg, majorVersion)),
+		},
+		declared:    f.Name(),
+		rcDirBefore: make(map[string]bool),
+	}
+	o.before = o.snapshot()
+	entries, _ := os.ReadDir(o.stage.config.RcFileDir)
+	for _, entry := range entries {
+		o.rcDirBefore[entry.Name()] = true
+	}
+	return o, nil
+}
+
+// remove deletes the file the script declared its outputs in
+func (o *scriptOutputs) remove() {
+	_ = os.Remove(o.declared)
+}
+
+// snapshot maps the final paths of the tracked files to their modification times in staging
+func (o *scriptOutputs) snapshot() map[string]time.Time {
+	snapshot := make(map[string]time.Time)
+	staged := o.stage.path(o.scriptDir)
+	entries, e := os.ReadDir(staged)
+	if e != nil {
+		log.Debug("could not read %s: %s", staged, e.Error())
+	}
+	for _, entry := range entries {
+		if info, e := entry.Info(); e == nil {
+			snapshot[filepath.Join(o.scriptDir, entry.Name())] = info.ModTime()
+		}
+	}
+	for _, path := range o.rcFiles {
+		if info, e := os.Lstat(o.stage.path(path)); e == nil {
+			snapshot[path] = info.ModTime()
+		}
+	}
+	return snapshot
+}
+
+// changed returns the new entries of the script directory, the rc files the script created or
+// rewrote, and the files it declared. Entries of the script directory that exist outside the
+// staging directory already were set up by an earlier install.
+func (o *scriptOutputs) changed() []string {
+	var changed []string
+	for path, modTime := range o.snapshot() {
+		t, existed := o.before[path]
+		if slices.Contains(o.rcFiles, path) {
+			if !existed || !t.Equal(modTime) {
+				changed = append(changed, path)
+			}
+		} else if !existed && !utils.Exists(path) {
+			changed = append(changed, path)
+		}
+	}
+	for _, path := range o.declaredPaths() {
+		if !slices.Contains(changed, path) {
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// declaredPaths reads the outputs the script declared. They must be staged in the packages
+// directory, or be new files in the rc file directory, since uninstall removes them.
+func (o *scriptOutputs) declaredPaths() []string {
+	b, e := os.ReadFile(o.declared)
+	if e != nil {
+		return nil
+	}
+	var paths []string
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		staged := filepath.Clean(line)
+		path, ok := o.stage.finalPath(staged)
+		if !ok || (filepath.Dir(path) == filepath.Clean(o.stage.config.RcFileDir) && o.rcDirBefore[filepath.Base(path)]) {
+			log.Warn("ignoring %s, declared by the setup script: only paths in %s and new files in %s are recorded",
+				line, o.stage.packages, o.stage.rc)
+			continue
+		}
+		if o.claimedElsewhere(path) {
+			log.Warn("ignoring %s, declared by the setup script: it holds what other installs set up", line)
+			continue
+		}
+		if _, e := os.Lstat(staged); e != nil {
+			log.Debug("ignoring %s, declared by the setup script: %s", line, e.Error())
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// claimedElsewhere is true if path contains the install location of a package, or the script
+// directory that other versions share
+func (o *scriptOutputs) claimedElsewhere(path string) bool {
+	if path == o.scriptDir || isInside(o.scriptDir, path) {
+		return true
+	}
+	reg, e := ReadRegistry(o.stage.config)
+	if e != nil {
+		return true
+	}
+	for _, p := range reg.Packages {
+		for _, owned := range ownedPaths(p) {
+			if owned == path || isInside(owned, path) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package rgx
+
+import (
+	"fmt"
+	"os"
+	"rgx/common/progress"
+	"rgx/common/utils"
+	sdk "rgx/pkg/rgx"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "manage the cache of downloaded artifacts",
+}
+
+var cacheListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list the files in the download cache",
+	Run:   cacheList,
+}
+
+var cacheSizeCmd = &cobra.Command{
+	Use:   "size",
+	Short: "show how much space the download cache takes",
+	Run:   cacheSize,
+}
+
+var cacheCleanCmd = &cobra.Command{
+	Use:         "clean",
+	Short:       "remove files from the download cache",
+	Run:         cacheClean,
+	Annotations: locksDirs,
+}
+
+var cacheVerifyCmd = &cobra.Command{
+	Use:         "verify",
+	Short:       "check the files in the download cache against their checksums",
+	Run:         cacheVerify,
+	Annotations: locksDirs,
+}
+
+func init() {
+	rootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cacheListCmd)
+	cacheCmd.AddCommand(cacheSizeCmd)
+	cacheCmd.AddCommand(cacheCleanCmd)
+	cacheCmd.AddCommand(cacheVerifyCmd)
+	cacheListCmd.Flags().BoolP("json", "", false, "print the cached files as json")
+	cacheCleanCmd.Flags().StringP("older-than", "", "", "only remove files last used longer ago than this, e.g. 30d or 12h")
+	cacheCleanCmd.Flags().BoolP("keep-installed", "", false, "keep the files installed packages were installed from")
+}
+
+func cacheList(cmd *cobra.Command, _ []string) {
+	setDebug(cmd)
+	asJson, _ := cmd.Flags().GetBool("json")
+	entries, e := client.CacheEntries()
+	exitOnError(e)
+	if asJson {
+		if entries == nil {
+			entries = []sdk.CacheEntry{}
+		}
+		fmt.Println(utils.PrettyPrint(entries))
+		return
+	}
+	if len(entries) == 0 {
+		fmt.Println("the download cache is empty")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "NAME\tSIZE\tLAST USED\tUSED BY\tKEY")
+	for _, entry := range entries {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", entry.Name, progress.FormatBytes(entry.Size),
+			entry.LastUsed.Local().Format("2006-01-02 15:04"), strings.Join(entry.Packages, ", "), shortKey(entry.Key))
+	}
+	_ = w.Flush()
+}
+
+// shortKey abbreviates the hash in a cache key, like git abbreviates commits
+func shortKey(key string) string {
+	algorithm, hash, _ := strings.Cut(key, "/")
+	if len(hash) > 12 {
+		hash = hash[:12]
+	}
+	return algorithm + "/" + hash
+}
+
+func cacheSize(cmd *cobra.Command, _ []string) {
+	setDebug(cmd)
+	files, size, e := client.CacheSize()
+	exitOnError(e)
+	fmt.Printf("%s in %d files\n", progress.FormatBytes(size), files)
+}
+
+func cacheClean(cmd *cobra.Command, args []string) {
+	var usage = `Usage: rgx cache clean [options]
+e.g.
+	rgx cache clean
+	rgx cache clean --older-than 30d
+	rgx cache clean --older-than 30d --keep-installed`
+
+	setDebug(cmd)
+	if len(args) != 0 {
+		fmt.Println(usage)
+		os.Exit(1)
+	}
+	keepInstalled, _ := cmd.Flags().GetBool("keep-installed")
+	var olderThan time.Duration
+	if s, _ := cmd.Flags().GetString("older-than"); s != "" {
+		var e error
+		if olderThan, e = parseAge(s); e != nil || olderThan <= 0 {
+			fmt.Printf("invalid --older-than %q\n", s)
+			fmt.Println(usage)
+			os.Exit(1)
+		}
+	}
+
+	removed, e := client.CleanCache(olderThan, keepInstalled)
+	var freed int64
+	for _, entry := range removed {
+		freed += entry.Size
+	}
+	fmt.Printf("removed %d files, freed %s\n", len(removed), progress.FormatBytes(freed))
+	exitOnError(e)
+}
+
+// parseAge parses a duration like time.ParseDuration does, and also accepts days, e.g. 30d
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, e := strconv.Atoi(days)
+		if e != nil {
+			return 0, e
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func cacheVerify(cmd *cobra.Command, _ []string) {
+	setDebug(cmd)
+	verified, corrupt, e := client.VerifyCache(cmd.Context())
+	for _, entry := range corrupt {
+		fmt.Printf("removed %s (%s), it did not match its checksum\n", entry.Name, shortKey(entry.Key))
+	}
+	fmt.Printf("verified %d files, %d corrupt\n", verified, len(corrupt))
+	exitOnError(e)
+}
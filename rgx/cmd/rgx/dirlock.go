@@ -0,0 +1,44 @@
+package rgx
+
+import (
+	"path/filepath"
+	"rgx/common/dirlock"
+
+	"github.com/spf13/cobra"
+)
+
+// locksDirs is set on commands that change the packages or download directory, they run
+// while holding the lock on both, so that rgx processes don't install over each other
+var locksDirs = map[string]string{locksDirsAnnotation: "true"}
+
+const locksDirsAnnotation = "rgx.locks-dirs"
+
+var heldLocks []*dirlock.Lock
+
+func lockDirs(cmd *cobra.Command) error {
+	if cmd.Annotations[locksDirsAnnotation] == "" {
+		return nil
+	}
+	timeout, _ := cmd.Flags().GetDuration("lock-timeout")
+	config := client.Config()
+	dirs := []string{config.PackagesDir}
+	if filepath.Clean(config.DownloadDir) != filepath.Clean(config.PackagesDir) {
+		dirs = append(dirs, config.DownloadDir)
+	}
+	for _, dir := range dirs {
+		l, e := dirlock.Acquire(cmd.Context(), dir, cmd.CommandPath(), timeout)
+		if e != nil {
+			releaseLocks()
+			return e
+		}
+		heldLocks = append(heldLocks, l)
+	}
+	return nil
+}
+
+func releaseLocks() {
+	for i := len(heldLocks) - 1; i >= 0; i-- {
+		heldLocks[i].Release()
+	}
+	heldLocks = nil
+}
@@ -0,0 +1,74 @@
+package rgx
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"rgx/common/utils"
+	"syscall"
+
+	"github.com/spf13/cobra"
+)
+
+var execCmd = &cobra.Command{
+	Use:   "exec",
+	Short: "run a binary from the version of a package in use",
+	// everything after the binary name belongs to the binary, including flags
+	DisableFlagParsing: true,
+	Run:                execBinary,
+}
+
+var whichCmd = &cobra.Command{
+	Use:   "which",
+	Short: "show the path of a binary from the version of a package in use",
+	Run:   which,
+}
+
+func init() {
+	rootCmd.AddCommand(execCmd)
+	rootCmd.AddCommand(whichCmd)
+}
+
+func execBinary(_ *cobra.Command, args []string) {
+	var usage = `Usage: rgx exec <package> <binary> [arguments]
+e.g.
+	rgx exec golang go version`
+
+	if len(args) < 2 {
+		fmt.Println(usage)
+		os.Exit(1)
+	}
+	path, e := client.Which(args[0], args[1])
+	exitOnError(e)
+	exitOnError(run(path, args[2:]))
+}
+
+// run replaces rgx with the binary where possible. If the binary fails, the error is an
+// *exec.ExitError carrying its exit code.
+func run(path string, args []string) error {
+	if utils.PlatformOS() != "windows" {
+		e := syscall.Exec(path, append([]string{path}, args...), os.Environ())
+		return fmt.Errorf("could not run %s: %w", path, e)
+	}
+
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func which(cmd *cobra.Command, args []string) {
+	var usage = `Usage: rgx which <package> <binary>
+e.g.
+	rgx which golang gofmt`
+
+	setDebug(cmd)
+	if len(args) != 2 {
+		fmt.Println(usage)
+		os.Exit(1)
+	}
+	path, e := client.Which(args[0], args[1])
+	exitOnError(e)
+	fmt.Println(path)
+}
@@ -0,0 +1,107 @@
+package rgx
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+
+	"rgx/candidates"
+	"rgx/common/dirlock"
+	"rgx/common/http"
+	"rgx/common/log"
+	"rgx/common/utils"
+)
+
+// Exit codes, one per class of failure, so that scripts can react to them
+const (
+	exitOK           = 0
+	exitGeneral      = 1  // anything else, including usage errors
+	exitConfig       = 2  // rgx.toml is missing or invalid
+	exitServer       = 3  // the rgx server or an artifact host could not be reached, or failed
+	exitNotFound     = 4  // no such package or version, or nothing matches the version asked for
+	exitChecksum     = 5  // a download does not match its checksum
+	exitExtract      = 6  // an archive could not be extracted
+	exitScript       = 7  // a setup script failed
+	exitNotInstalled = 8  // the package or version is not installed
+	exitLockMismatch = 9  // rgx.lock is missing, incomplete or out of date
+	exitUnsafePath   = 10 // rgx refused to touch a path outside the directories it manages
+	exitProjectFile  = 12 // .rgx-versions is missing or invalid; 11 is used by log.Fatal
+	exitRegistry     = 13 // the install registry could not be read or written
+	exitBadResponse  = 14 // the server answered with something rgx does not understand
+	exitLockTimeout  = 15 // another rgx process kept the packages directory locked for too long
+	exitSignature    = 16 // a recipe or artifact is not signed by a trusted key
+	exitInterrupted  = 130
+)
+
+const exitCodesHelp = `
+Exit codes:
+  0   success
+  1   general or usage error
+  2   rgx.toml is missing or invalid
+  3   the rgx server or an artifact host is unavailable
+  4   package or version not found
+  5   checksum mismatch
+  6   extraction failed
+  7   setup script failed
+  8   package or version not installed
+  9   rgx.lock is missing, incomplete or out of date
+  10  refused to touch an unsafe path
+  12  .rgx-versions is missing or invalid
+  13  the install registry could not be read or written
+  14  unexpected server response
+  15  timed out waiting for another rgx process
+  16  signature verification failed
+  130 interrupted`
+
+var exitCodes = []struct {
+	err  error
+	code int
+}{
+	// checked first, since an interrupted download or script also wraps its own error class
+	{context.Canceled, exitInterrupted},
+	{utils.ErrConfig, exitConfig},
+	{http.ErrServerUnavailable, exitServer},
+	{http.ErrNotFound, exitNotFound},
+	{candidates.ErrPackageNotFound, exitNotFound},
+	{candidates.ErrNoMatchingVersion, exitNotFound},
+	{utils.ErrChecksumMismatch, exitChecksum},
+	{utils.ErrExtract, exitExtract},
+	{utils.ErrScript, exitScript},
+	{candidates.ErrNotInstalled, exitNotInstalled},
+	{candidates.ErrLockMismatch, exitLockMismatch},
+	{candidates.ErrUnsafePath, exitUnsafePath},
+	{candidates.ErrProjectFile, exitProjectFile},
+	{candidates.ErrRegistry, exitRegistry},
+	{http.ErrBadResponse, exitBadResponse},
+	{dirlock.ErrTimeout, exitLockTimeout},
+	{utils.ErrSignature, exitSignature},
+}
+
+func exitCode(e error) int {
+	if e == nil {
+		return exitOK
+	}
+	for _, c := range exitCodes {
+		if errors.Is(e, c.err) {
+			return c.code
+		}
+	}
+	return exitGeneral
+}
+
+// exitOnError logs e and exits with the code for its class of failure; it does nothing if e is nil
+func exitOnError(e error) {
+	if e == nil {
+		return
+	}
+	// a binary run through rgx exec failed, and has already reported why
+	var exitErr *exec.ExitError
+	if errors.As(e, &exitErr) {
+		releaseLocks()
+		os.Exit(exitErr.ExitCode())
+	}
+	releaseLocks()
+	log.Error("%s", e.Error())
+	os.Exit(exitCode(e))
+}
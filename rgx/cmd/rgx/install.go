@@ -3,34 +3,58 @@ package rgx
 import (
 	"fmt"
 	"os"
-	"rgx/candidates"
 
 	"github.com/spf13/cobra"
 )
 
 var installCmd = &cobra.Command{
-	Use:   "install",
-	Short: "install a package",
-	Run:   install,
+	Use:         "install",
+	Short:       "install a package",
+	Run:         install,
+	Annotations: locksDirs,
 }
 
 func init() {
 	rootCmd.AddCommand(installCmd)
 	installCmd.Flags().BoolP("lts", "", false, "only consider LTS releases")
+	installCmd.Flags().BoolP("frozen", "", false, "install exactly what rgx.lock records")
+	installCmd.Flags().BoolP("allow-unverified", "", false, "install artifacts the server publishes no checksum for")
 }
 
 func install(cmd *cobra.Command, args []string) {
-	var usage = `Usage: rgx install openjdk <version>
+	var usage = `Usage: rgx install [<package> <version>]
 e.g.
 	rgx install openjdk latest
 	rgx install openjdk latest --lts
-	rgx install openjdk 20`
+	rgx install openjdk 20
+	rgx install golang 1.22.3
+	rgx install golang 1.22.x
+	rgx install golang '~1.21'
+	rgx install golang '>=1.20 <1.23'
+	rgx install            (installs the packages listed in .rgx-versions)
+	rgx install --frozen   (installs the packages recorded in rgx.lock)`
 
 	setDebug(cmd)
 	lts, _ := cmd.Flags().GetBool("lts")
+	frozen, _ := cmd.Flags().GetBool("frozen")
+	if frozen {
+		if len(args) != 0 {
+			fmt.Println(usage)
+			os.Exit(1)
+		}
+		_, e := client.InstallFrozen(cmd.Context())
+		exitOnError(e)
+		return
+	}
+	if len(args) == 0 {
+		_, e := client.InstallProject(cmd.Context(), lts)
+		exitOnError(e)
+		return
+	}
 	if len(args) != 2 {
 		fmt.Println(usage)
 		os.Exit(1)
 	}
-	candidates.Install(args[0], args[1], lts)
+	_, e := client.Install(cmd.Context(), args[0], args[1], lts)
+	exitOnError(e)
 }
@@ -0,0 +1,45 @@
+package rgx
+
+import (
+	"fmt"
+	"os"
+	"rgx/common/utils"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list packages installed on this machine",
+	Run:   listInstalled,
+}
+
+func init() {
+	rootCmd.AddCommand(listCmd)
+	listCmd.Flags().BoolP("json", "", false, "print the installed packages as json")
+}
+
+func listInstalled(cmd *cobra.Command, _ []string) {
+	setDebug(cmd)
+	asJson, _ := cmd.Flags().GetBool("json")
+	pkgs, e := client.Installed()
+	exitOnError(e)
+	if asJson {
+		fmt.Println(utils.PrettyPrint(pkgs))
+		return
+	}
+	if len(pkgs) == 0 {
+		fmt.Println("no packages installed")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "PACKAGE\tMAJOR\tVERSION\tINSTALLED\tTARGETS")
+	for _, p := range pkgs {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", p.Package, p.MajorVersion, p.PackageVersion,
+			p.InstalledAt.Local().Format("2006-01-02 15:04"), strings.Join(p.Targets(), ", "))
+	}
+	_ = w.Flush()
+}
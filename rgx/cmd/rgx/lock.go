@@ -0,0 +1,22 @@
+package rgx
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var lockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "resolve the packages in .rgx-versions to exact versions and write rgx.lock",
+	Run:   lock,
+}
+
+func init() {
+	rootCmd.AddCommand(lockCmd)
+	lockCmd.Flags().BoolP("lts", "", false, "only consider LTS releases")
+}
+
+func lock(cmd *cobra.Command, _ []string) {
+	setDebug(cmd)
+	lts, _ := cmd.Flags().GetBool("lts")
+	exitOnError(client.Lock(cmd.Context(), lts))
+}
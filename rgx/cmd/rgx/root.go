@@ -1,9 +1,15 @@
 package rgx
 
 import (
+	"context"
 	"os"
+	"os/signal"
 	"rgx/common/log"
+	"rgx/common/progress"
 	"rgx/common/utils"
+	sdk "rgx/pkg/rgx"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -12,7 +18,22 @@ var rootCmd = &cobra.Command{
 	Use:     utils.ApplicationName,
 	Version: utils.Version,
 	Short:   utils.ApplicationName + ":" + utils.ApplicationShortDescription,
-	Long:    utils.ApplicationDescription,
+	Long:    utils.ApplicationDescription + "\n" + exitCodesHelp,
+	PersistentPreRun: func(cmd *cobra.Command, _ []string) {
+		quiet, _ := cmd.Flags().GetBool("quiet")
+		progress.Configure(quiet || !client.Config().ShowProgress)
+		if allow, _ := cmd.Flags().GetBool("allow-unverified"); allow {
+			config := client.Config()
+			config.AllowUnverified = true
+			var e error
+			client, e = sdk.NewClient(config)
+			exitOnError(e)
+		}
+		exitOnError(lockDirs(cmd))
+	},
+	PersistentPostRun: func(_ *cobra.Command, _ []string) {
+		releaseLocks()
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) == 0 {
 			_ = cmd.Help()
@@ -21,16 +42,36 @@ var rootCmd = &cobra.Command{
 	},
 }
 
+// client does the work for the commands, which only parse arguments and print results
+var client *sdk.Client
+
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
-		log.Error("Error running command: ", err)
-		os.Exit(1)
+	config, e := sdk.ReadConfig()
+	exitOnError(e)
+	client, e = sdk.NewClient(config)
+	exitOnError(e)
+
+	// the first Ctrl-C cancels the command, which then cleans up after itself; a second one
+	// stops rgx straight away
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+		log.Warn("interrupted, cleaning up")
+	}()
+
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
+		releaseLocks()
+		log.Error("Error running command: %s", err.Error())
+		os.Exit(exitGeneral)
 	}
 }
 
 func init() {
 	rootCmd.PersistentFlags().Bool("debug", false, "Display debug messages (false, by default)")
 	rootCmd.PersistentFlags().Bool("trace", false, "Display trace messages (false, by default)")
+	rootCmd.PersistentFlags().BoolP("quiet", "q", false, "Don't show download and extraction progress")
+	rootCmd.PersistentFlags().Duration("lock-timeout", 10*time.Minute, "How long to wait for another rgx process to finish installing, 0 to fail straight away")
 }
 
 // TODO refactor this
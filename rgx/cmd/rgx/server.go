@@ -3,7 +3,6 @@ package rgx
 import (
 	"fmt"
 	"os"
-	"rgx/candidates"
 
 	"github.com/spf13/cobra"
 )
@@ -30,25 +29,37 @@ func init() {
 	serverCmd.AddCommand(serverListCmd)
 	serverCmd.AddCommand(serverShowCmd)
 	serverShowCmd.Flags().BoolP("lts", "", false, "only consider LTS releases")
+	serverShowCmd.Flags().BoolP("all", "", false, "show every release, not just the major versions")
 }
 
 func list(cmd *cobra.Command, _ []string) {
 	setDebug(cmd)
-	candidates.PrintServerPackages()
+	pkgs, e := client.ListServerPackages(cmd.Context())
+	exitOnError(e)
+	for _, r := range pkgs {
+		fmt.Printf("%s - %s\n", r.Name, r.Description)
+	}
 }
 
 func show(cmd *cobra.Command, args []string) {
 	var usage = `Usage: rgx server show <package> [options]
 e.g.
 	rgx server show openjdk
-	rgx server show openjdk --lts`
+	rgx server show openjdk --lts
+	rgx server show golang --all`
 
 	setDebug(cmd)
 	lts, _ := cmd.Flags().GetBool("lts")
+	all, _ := cmd.Flags().GetBool("all")
 	if len(args) != 1 {
 		fmt.Println(usage)
 		os.Exit(1)
 	}
 
-	candidates.PrintMajorVersions(args[0], lts)
+	versions, e := client.Versions(cmd.Context(), args[0], lts, all)
+	exitOnError(e)
+	for _, r := range versions {
+		fmt.Printf("%s ", r)
+	}
+	fmt.Println()
 }
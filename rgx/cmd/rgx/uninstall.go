@@ -0,0 +1,43 @@
+package rgx
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var uninstallCmd = &cobra.Command{
+	Use:         "uninstall",
+	Short:       "remove an installed package",
+	Run:         uninstall,
+	Annotations: locksDirs,
+}
+
+func init() {
+	rootCmd.AddCommand(uninstallCmd)
+	uninstallCmd.Flags().BoolP("dry-run", "", false, "only print what would be removed")
+}
+
+func uninstall(cmd *cobra.Command, args []string) {
+	var usage = `Usage: rgx uninstall <package> <version> [options]
+e.g.
+	rgx uninstall golang 1.21
+	rgx uninstall golang 1.21.5 --dry-run`
+
+	setDebug(cmd)
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	if len(args) != 2 {
+		fmt.Println(usage)
+		os.Exit(1)
+	}
+	if dryRun {
+		paths, e := client.UninstallDryRun(args[0], args[1])
+		exitOnError(e)
+		for _, path := range paths {
+			fmt.Printf("would remove %s\n", path)
+		}
+		return
+	}
+	exitOnError(client.Uninstall(args[0], args[1]))
+}
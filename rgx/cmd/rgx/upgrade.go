@@ -0,0 +1,69 @@
+package rgx
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var outdatedCmd = &cobra.Command{
+	Use:   "outdated",
+	Short: "list installed packages that have a newer patch release on the server",
+	Run:   outdated,
+}
+
+var upgradeCmd = &cobra.Command{
+	Use:         "upgrade",
+	Short:       "install the newest patch release of installed packages",
+	Run:         upgrade,
+	Annotations: locksDirs,
+}
+
+func init() {
+	rootCmd.AddCommand(outdatedCmd)
+	rootCmd.AddCommand(upgradeCmd)
+	upgradeCmd.Flags().BoolP("prune", "", false, "remove the release that was upgraded")
+	upgradeCmd.Flags().BoolP("allow-unverified", "", false, "install artifacts the server publishes no checksum for")
+}
+
+func outdated(cmd *cobra.Command, _ []string) {
+	setDebug(cmd)
+	outdated, e := client.Outdated(cmd.Context(), "")
+	exitOnError(e)
+	if len(outdated) == 0 {
+		fmt.Println("all installed packages are up to date")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "PACKAGE\tMAJOR\tINSTALLED\tLATEST")
+	for _, o := range outdated {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", o.Installed.Package, o.Installed.MajorVersion,
+			o.Installed.PackageVersion, o.Latest)
+	}
+	_ = w.Flush()
+}
+
+func upgrade(cmd *cobra.Command, args []string) {
+	var usage = `Usage: rgx upgrade [package] [options]
+e.g.
+	rgx upgrade
+	rgx upgrade golang --prune`
+
+	setDebug(cmd)
+	prune, _ := cmd.Flags().GetBool("prune")
+	if len(args) > 1 {
+		fmt.Println(usage)
+		os.Exit(1)
+	}
+	var pkg string
+	if len(args) == 1 {
+		pkg = args[0]
+	}
+	upgraded, e := client.Upgrade(cmd.Context(), pkg, prune)
+	exitOnError(e)
+	if len(upgraded) == 0 {
+		fmt.Println("all installed packages are up to date")
+	}
+}
@@ -0,0 +1,73 @@
+package rgx
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var useCmd = &cobra.Command{
+	Use:         "use",
+	Short:       "make an installed version of a package the active one",
+	Run:         use,
+	Annotations: locksDirs,
+}
+
+var currentCmd = &cobra.Command{
+	Use:   "current",
+	Short: "show the active version of installed packages",
+	Run:   current,
+}
+
+func init() {
+	rootCmd.AddCommand(useCmd)
+	rootCmd.AddCommand(currentCmd)
+}
+
+func use(cmd *cobra.Command, args []string) {
+	var usage = `Usage: rgx use <package> <version>
+e.g.
+	rgx use golang 1.22
+	rgx use golang 1.22.3`
+
+	setDebug(cmd)
+	if len(args) != 2 {
+		fmt.Println(usage)
+		os.Exit(1)
+	}
+	exitOnError(client.Use(args[0], args[1]))
+}
+
+func current(cmd *cobra.Command, args []string) {
+	var usage = `Usage: rgx current [package]
+e.g.
+	rgx current
+	rgx current golang`
+
+	setDebug(cmd)
+	if len(args) > 1 {
+		fmt.Println(usage)
+		os.Exit(1)
+	}
+	var pkg string
+	if len(args) == 1 {
+		pkg = args[0]
+	}
+	current, e := client.Current(pkg)
+	exitOnError(e)
+	if len(current) == 0 {
+		fmt.Println("no active packages, see: rgx use")
+		return
+	}
+	for _, c := range current {
+		switch {
+		case c.Version == "":
+			fmt.Printf("%s: no active version\n", c.Package)
+		case c.PackageVersion == "":
+			fmt.Printf("%s %s (not installed, set by %s)\n", c.Package, c.Version, c.Source)
+		default:
+			fmt.Printf("%s %s -> %s (set by %s)\n", c.Package, c.PackageVersion, c.Home, c.Source)
+		}
+	}
+}
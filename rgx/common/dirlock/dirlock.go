@@ -0,0 +1,185 @@
+// Package dirlock keeps rgx processes from changing the same directory at the same time.
+// It takes an advisory lock on a file in the directory, flock on Linux and macOS and
+// LockFileEx on Windows. The lock is released by the operating system when the process dies.
+// On filesystems that can't lock files, an exclusively created owner file is the lock, and it
+// is taken over when the process that created it is no longer running.
+package dirlock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"rgx/common/log"
+)
+
+const (
+	lockFile  = ".rgx.lock"
+	ownerFile = ".rgx.lock.owner"
+	pollEvery = 200 * time.Millisecond
+)
+
+var ErrTimeout = errors.New("timed out waiting for another rgx process")
+
+// errUnsupported is returned by tryLock if the filesystem can't lock files
+var errUnsupported = errors.New("file locking is not supported")
+
+type Lock struct {
+	dir  string
+	file *os.File
+	// owned is true if the owner file is the lock, because the file could not be locked
+	owned bool
+}
+
+// owner is written to the owner file, so that others can tell who holds the lock
+type owner struct {
+	Pid     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Command string    `json:"command"`
+	Since   time.Time `json:"since"`
+}
+
+func (o owner) String() string {
+	if o.Pid == 0 {
+		return "unknown pid"
+	}
+	return fmt.Sprintf("pid %d on %s, running %q since %s", o.Pid, o.Host, o.Command, o.Since.Local().Format("15:04:05"))
+}
+
+// stale is true if o was written by a process on this host that is no longer running
+func (o owner) stale() bool {
+	host, _ := os.Hostname()
+	return o.Pid != 0 && o.Host == host && !processAlive(o.Pid)
+}
+
+// Acquire locks dir for command, waiting up to timeout for another process to release it
+func Acquire(ctx context.Context, dir, command string, timeout time.Duration) (*Lock, error) {
+	if e := os.MkdirAll(dir, 0775); e != nil {
+		return nil, e
+	}
+	path := filepath.Join(dir, lockFile)
+	f, e := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0666)
+	if e != nil {
+		return nil, fmt.Errorf("could not open %s: %w", path, e)
+	}
+	l := &Lock{dir: dir, file: f}
+
+	deadline := time.Now().Add(timeout)
+	var waitingFor owner
+	for {
+		ok, e := l.try(command)
+		if e != nil {
+			_ = f.Close()
+			return nil, e
+		}
+		if ok {
+			return l, nil
+		}
+
+		holder := readOwner(dir)
+		if holder != waitingFor {
+			log.Info("waiting for another rgx process (%s) to release %s", holder, dir)
+			waitingFor = holder
+		}
+		if !time.Now().Before(deadline) {
+			_ = f.Close()
+			return nil, fmt.Errorf("%w (%s) to release %s after %s, use --lock-timeout to wait longer",
+				ErrTimeout, holder, dir, timeout)
+		}
+		select {
+		case <-ctx.Done():
+			_ = f.Close()
+			return nil, ctx.Err()
+		case <-time.After(pollEvery):
+		}
+	}
+}
+
+// try takes the lock if it is free. If the filesystem can't lock files, the owner file is
+// created exclusively instead.
+func (l *Lock) try(command string) (bool, error) {
+	ok, e := tryLock(l.file)
+	if errors.Is(e, errUnsupported) {
+		log.Debug("%s: %s, using %s as the lock", l.dir, e.Error(), ownerFile)
+		ok, e = l.tryOwnerFile(command)
+		l.owned = ok
+		return ok, e
+	}
+	if e != nil || !ok {
+		return false, e
+	}
+	writeOwner(l.dir, command, false)
+	return true, nil
+}
+
+func (l *Lock) tryOwnerFile(command string) (bool, error) {
+	if writeOwner(l.dir, command, true) {
+		return true, nil
+	}
+	holder := readOwner(l.dir)
+	if !holder.stale() {
+		return false, nil
+	}
+	log.Warn("removing the stale lock of %s, which is no longer running", holder)
+	if e := os.Remove(filepath.Join(l.dir, ownerFile)); e != nil && !os.IsNotExist(e) {
+		return false, e
+	}
+	return writeOwner(l.dir, command, true), nil
+}
+
+// Release unlocks the directory, it is safe to call more than once
+func (l *Lock) Release() {
+	if l == nil || l.file == nil {
+		return
+	}
+	if e := os.Remove(filepath.Join(l.dir, ownerFile)); e != nil && !os.IsNotExist(e) {
+		log.Debug("could not remove %s: %s", ownerFile, e.Error())
+	}
+	if !l.owned {
+		if e := unlock(l.file); e != nil {
+			log.Debug("could not unlock %s: %s", l.dir, e.Error())
+		}
+	}
+	_ = l.file.Close()
+	l.file = nil
+}
+
+// writeOwner records this process as the owner of the lock. It reports whether the file was
+// written, with exclusive set it fails if the file exists.
+func writeOwner(dir, command string, exclusive bool) bool {
+	host, _ := os.Hostname()
+	b, _ := json.Marshal(owner{Pid: os.Getpid(), Host: host, Command: command, Since: time.Now()})
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if exclusive {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	}
+	path := filepath.Join(dir, ownerFile)
+	f, e := os.OpenFile(path, flags, 0666)
+	if e != nil {
+		if !exclusive {
+			log.Debug("could not write %s: %s", path, e.Error())
+		}
+		return false
+	}
+	_, e = f.Write(b)
+	if closeErr := f.Close(); e == nil {
+		e = closeErr
+	}
+	if e != nil {
+		log.Debug("could not write %s: %s", path, e.Error())
+	}
+	return true
+}
+
+func readOwner(dir string) owner {
+	var o owner
+	b, e := os.ReadFile(filepath.Join(dir, ownerFile))
+	if e == nil {
+		_ = json.Unmarshal(b, &o)
+	}
+	return o
+}
@@ -0,0 +1,32 @@
+//go:build !windows
+
+package dirlock
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+func tryLock(f *os.File) (bool, error) {
+	e := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	switch {
+	case e == nil:
+		return true, nil
+	case errors.Is(e, syscall.EWOULDBLOCK):
+		return false, nil
+	case errors.Is(e, syscall.ENOLCK), errors.Is(e, syscall.EOPNOTSUPP), errors.Is(e, syscall.ENOSYS):
+		return false, errUnsupported
+	default:
+		return false, e
+	}
+}
+
+func unlock(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
+
+func processAlive(pid int) bool {
+	e := syscall.Kill(pid, 0)
+	return e == nil || errors.Is(e, syscall.EPERM)
+}
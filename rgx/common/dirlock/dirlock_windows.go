@@ -0,0 +1,48 @@
+package dirlock
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// stillActive is the exit code GetExitCodeProcess reports for a running process
+const stillActive = 259
+
+func tryLock(f *os.File) (bool, error) {
+	var ol windows.Overlapped
+	flags := uint32(windows.LOCKFILE_EXCLUSIVE_LOCK | windows.LOCKFILE_FAIL_IMMEDIATELY)
+	e := windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, 1, 0, &ol)
+	switch {
+	case e == nil:
+		return true, nil
+	case errors.Is(e, windows.ERROR_LOCK_VIOLATION), errors.Is(e, windows.ERROR_IO_PENDING):
+		return false, nil
+	case errors.Is(e, windows.ERROR_NOT_SUPPORTED), errors.Is(e, windows.ERROR_INVALID_FUNCTION):
+		return false, errUnsupported
+	default:
+		return false, e
+	}
+}
+
+func unlock(f *os.File) error {
+	var ol windows.Overlapped
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, &ol)
+}
+
+func processAlive(pid int) bool {
+	h, e := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if e != nil {
+		// access is denied to processes of other users, which are running
+		return errors.Is(e, windows.ERROR_ACCESS_DENIED)
+	}
+	defer func() {
+		_ = windows.CloseHandle(h)
+	}()
+	var code uint32
+	if e := windows.GetExitCodeProcess(h, &code); e != nil {
+		return true
+	}
+	return code == stillActive
+}
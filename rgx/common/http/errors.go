@@ -0,0 +1,9 @@
+package http
+
+import "errors"
+
+var (
+	ErrServerUnavailable = errors.New("server unavailable")
+	ErrNotFound          = errors.New("not found")
+	ErrBadResponse       = errors.New("unexpected server response")
+)
@@ -1,161 +1,287 @@
 package http
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"rgx/common/log"
+	"rgx/common/progress"
 	"rgx/common/utils"
 )
 
 type TextResponse struct {
 	Text         string
 	ResponseCode int
+	Header       http.Header
 }
 
-func GetText(url string) (TextResponse, error) {
-	client, req := setup(url, &utils.Config)
+// GetText wraps errors in ErrNotFound for a 404, in ErrServerUnavailable if the server can't
+// be reached or fails, and in ErrBadResponse otherwise. Transient failures are retried.
+func GetText(ctx context.Context, config *utils.RgxConfig, url string) (TextResponse, error) {
+	var resp TextResponse
+	e := withRetry(ctx, config, url, func() (e error) {
+		resp, e = getText(ctx, config, url)
+		return
+	})
+	return resp, e
+}
+
+func getText(ctx context.Context, config *utils.RgxConfig, url string) (TextResponse, error) {
+	client, req := setup(ctx, url, config)
+	// responses are small, so http_timeout covers the whole request
+	client.Timeout = config.HttpTimeout
 	resp, e := client.Do(req)
 	if e != nil {
-		return TextResponse{"", 0}, e
+		return TextResponse{}, failedRequest(ctx, e)
 	}
-	if resp.StatusCode != 200 {
-		return TextResponse{"", resp.StatusCode}, errors.New(resp.Status)
+	defer closeBody(resp.Body)
+	if e = checkStatus(url, resp); e != nil {
+		return TextResponse{ResponseCode: resp.StatusCode}, e
 	}
 	respBody, e := io.ReadAll(resp.Body)
 	if e != nil {
-		return TextResponse{"", 0}, e
+		return TextResponse{}, failedRequest(ctx, e)
+	}
+	return TextResponse{Text: string(respBody), ResponseCode: 200, Header: resp.Header}, nil
+}
+
+// failedRequest returns the context's error if the request was cancelled, so that callers can
+// tell an interrupt from a failing server
+func failedRequest(ctx context.Context, e error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
-	return TextResponse{string(respBody), 200}, nil
+	return &requestError{err: e, transient: transient(e)}
 }
 
-func Download(url, targetFile string, cksum utils.Checksum) (error, bool) {
-	client, req := setup(url, &utils.Config)
+func checkStatus(url string, resp *http.Response) error {
+	e := &statusError{url: url, code: resp.StatusCode, status: resp.Status}
+	switch {
+	case resp.StatusCode == 200:
+		return nil
+	case resp.StatusCode == 404:
+		e.class = ErrNotFound
+	case resp.StatusCode == 429 || resp.StatusCode >= 500:
+		e.class = ErrServerUnavailable
+		e.retryAfter = retryAfter(resp)
+	default:
+		e.class = ErrBadResponse
+	}
+	return e
+}
+
+func closeBody(body io.ReadCloser) {
+	err := body.Close()
+	if err != nil {
+		log.Error("could not close http response body: %s", err.Error())
+	}
+}
+
+// Download saves url to targetFile. If an earlier attempt left a partial download behind, only
+// the rest of the file is requested, provided the server still has the same file; otherwise
+// the download starts over. The partial file is kept when a resumable download fails, and
+// removed when it can't be resumed. A resumed download that fails its checksum is downloaded
+// once more from the start. Transient failures are retried, resuming where possible.
+func Download(ctx context.Context, config *utils.RgxConfig, url, targetFile string, sums []utils.Checksum) error {
+	return withRetry(ctx, config, url, func() error {
+		return download(ctx, config, url, targetFile, sums)
+	})
+}
+
+func download(ctx context.Context, config *utils.RgxConfig, url, targetFile string, sums []utils.Checksum) error {
+	tempFile := targetFile + ".rgxdownload"
+	offset, validator := resumeOffset(tempFile)
+
+	client, req := setup(ctx, url, config)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", validator)
+	}
 	resp, e := client.Do(req)
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			log.Error("could not close http response body: %s", err.Error())
+	if e != nil {
+		return failedRequest(ctx, e)
+	}
+	defer closeBody(resp.Body)
+
+	switch {
+	case offset > 0 && resp.StatusCode == http.StatusPartialContent && rangeStart(resp) == offset:
+		log.Info("resuming download of %s at %d bytes", url, offset)
+	case offset > 0 && (resp.StatusCode == http.StatusRequestedRangeNotSatisfiable ||
+		resp.StatusCode == http.StatusPartialContent):
+		// the partial file is not a prefix of what the server has now, or the server sent a
+		// different range than the one asked for
+		log.Debug("the server rejected the range request for %s, starting over", url)
+		removePartial(tempFile)
+		closeBody(resp.Body)
+		return download(ctx, config, url, targetFile, sums)
+	default:
+		if offset > 0 {
+			log.Debug("the server does not support resuming %s, starting over", url)
 		}
-	}(resp.Body)
-	utils.ErrCheck(e)
+		offset = 0
+		if e = checkStatus(url, resp); e != nil {
+			removePartial(tempFile)
+			return e
+		}
+	}
 
-	var downloadSize uint64
-	var showDownloadProgress bool
+	var downloadSize int64
 	if clen, ok := resp.Header["Content-Length"]; ok {
-		downloadSize, e = strconv.ParseUint(clen[0], 10, 64)
+		downloadSize, e = strconv.ParseInt(clen[0], 10, 64)
 		if e != nil {
 			log.Warn("invalid value in content-length header")
 		}
 	}
+	log.Debug("about to download %v bytes", downloadSize)
 
-	if downloadSize > 600000 {
-		if utils.Config.ShowProgress {
-			showDownloadProgress = true
-		}
-		log.Debug("about to download %v bytes\n", downloadSize)
+	var out *os.File
+	if offset > 0 {
+		out, e = os.OpenFile(tempFile, os.O_WRONLY|os.O_APPEND, 0664)
+	} else {
+		out, e = os.Create(tempFile)
 	}
-
-	tempFile := targetFile + ".rgxdownload"
-	out, e := os.Create(tempFile)
-	utils.ErrCheck(e)
-
-	if showDownloadProgress {
-		counter := &WriteCounter{TotalBytes: downloadSize}
-		if _, e = io.Copy(out, io.TeeReader(resp.Body, counter)); e != nil {
-			err := out.Close()
-			if err != nil {
-				log.Error("could not close downloaded file: %s", err.Error())
-			}
-		}
+	if e != nil {
+		return e
 	}
-	fmt.Printf("\r%s\r", strings.Repeat(" ", 40))
+	resumable := saveValidator(tempFile, resp)
+	succeeded := false
+	defer func() {
+		if !succeeded && !resumable {
+			removePartial(tempFile)
+		} else if !succeeded {
+			log.Info("kept the partial download %s, it will be resumed next time", tempFile)
+		}
+	}()
 
-	_, e = io.Copy(out, resp.Body)
+	tracker := progress.Start("downloading "+filepath.Base(targetFile), offset+downloadSize)
+	tracker.Add(offset)
+	_, e = io.Copy(out, progress.Reader(resp.Body, tracker))
+	tracker.Done()
 	if e != nil {
-		return e, false
+		_ = out.Close()
+		return failedRequest(ctx, e)
 	}
 	e = out.Close()
 	if e != nil {
-		return e, false
+		return e
 	}
 	if utils.Exists(targetFile) {
 		e = os.Remove(targetFile)
 		if e != nil {
-			return e, false
+			return e
 		}
 	}
 	e = os.Rename(tempFile, targetFile)
 	if e != nil {
-		return e, false
+		return e
 	}
+	succeeded = true
+	removeValidator(tempFile)
 
-	if cksum.Hash == "" {
-		return nil, utils.Exists(targetFile)
-	} else {
-		s, e := utils.Hash(targetFile, cksum.Algorithm)
-		if e != nil {
-			return e, false
-		}
-		if s.Hash != strings.ToLower(strings.TrimSpace(cksum.Hash)) {
-			log.Debug("checksum mismatch for: %s, expected %s but got %s", targetFile, cksum.Hash, s.Hash)
-			return errors.New("checksum mismatch"), false
-		} else {
-			return nil, true
-		}
+	e = utils.Verify(targetFile, sums)
+	if e != nil && offset > 0 {
+		// the part downloaded earlier may be what is wrong, so fetch the whole file once more
+		log.Info("the resumed download of %s does not match its checksum, starting over", url)
+		return download(ctx, config, url, targetFile, sums)
 	}
+	return e
+}
+
+func SaveUrl(ctx context.Context, config *utils.RgxConfig, url, targetFile string) error {
+	return withRetry(ctx, config, url, func() error {
+		return saveUrl(ctx, config, url, targetFile)
+	})
 }
 
-func SaveUrl(url, targetFile string) (error, bool) {
-	client, req := setup(url, &utils.Config)
+func saveUrl(ctx context.Context, config *utils.RgxConfig, url, targetFile string) error {
+	client, req := setup(ctx, url, config)
+	client.Timeout = config.HttpTimeout
 	resp, e := client.Do(req)
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			log.Error("could not close http response body: %s", err.Error())
-		}
-	}(resp.Body)
-	utils.ErrCheck(e)
+	if e != nil {
+		return failedRequest(ctx, e)
+	}
+	defer closeBody(resp.Body)
+	if e = checkStatus(url, resp); e != nil {
+		return e
+	}
 
 	tempFile := targetFile + ".rgxdownload"
 	out, e := os.Create(tempFile)
-	utils.ErrCheck(e)
+	if e != nil {
+		return e
+	}
+	defer removePartial(tempFile)
 
 	_, e = io.Copy(out, resp.Body)
 	if e != nil {
-		return e, false
+		_ = out.Close()
+		return failedRequest(ctx, e)
 	}
 	e = out.Close()
 	if e != nil {
-		return e, false
+		return e
 	}
 	if utils.Exists(targetFile) {
 		e = os.Remove(targetFile)
 		if e != nil {
-			return e, false
+			return e
 		}
 	}
-	e = os.Rename(tempFile, targetFile)
-	if e != nil {
-		return e, false
+	return os.Rename(tempFile, targetFile)
+}
+
+// removePartial deletes a temporary download that was not renamed into place
+func removePartial(tempFile string) {
+	removeValidator(tempFile)
+	if !utils.Exists(tempFile) {
+		return
 	}
+	if e := os.Remove(tempFile); e != nil {
+		log.Warn("could not remove partial download %s: %s", tempFile, e.Error())
+	}
+}
 
-	return nil, true
+var (
+	transportLock    sync.Mutex
+	transport        *http.Transport
+	transportTimeout time.Duration
+)
+
+// sharedTransport limits connecting and waiting for response headers to timeout, but not
+// reading the body, so that large downloads on slow connections still succeed
+func sharedTransport(timeout time.Duration) *http.Transport {
+	transportLock.Lock()
+	defer transportLock.Unlock()
+	if transport == nil || transportTimeout != timeout {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.DialContext = (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext
+		t.TLSHandshakeTimeout = timeout
+		t.ResponseHeaderTimeout = timeout
+		transport, transportTimeout = t, timeout
+	}
+	return transport
 }
 
-func setup(url string, config *utils.RgxConfig) (*http.Client, *http.Request) {
-	client := &http.Client{}
-	req, _ := http.NewRequest("GET", url, nil)
+func setup(ctx context.Context, url string, config *utils.RgxConfig) (*http.Client, *http.Request) {
+	client := &http.Client{Transport: sharedTransport(config.HttpTimeout)}
+	req, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
 	req.Header.Set("User-Agent", userAgent())
 	if strings.HasPrefix(url, config.ArtifactRegistryBase) && config.ArtifactRegistryAuth != "" {
-		log.Trace("adding well known credentials to request")
-		u, p := credentials(config.ArtifactRegistryAuth)
-		req.SetBasicAuth(u, p)
+		if u, p, ok := strings.Cut(config.ArtifactRegistryAuth, ":"); ok {
+			log.Trace("adding well known credentials to request")
+			req.SetBasicAuth(u, p)
+		} else {
+			log.Warn("ignoring artifact registry credentials, expected <user>:<password>")
+		}
 	}
 	if strings.HasPrefix(url, config.ServerUrl) {
 		req.Header.Set("x-rgx-installation", utils.CurrentRuntimeConfig.AsHeader())
@@ -166,36 +292,3 @@ func setup(url string, config *utils.RgxConfig) (*http.Client, *http.Request) {
 func userAgent() string {
 	return utils.UserAgent
 }
-
-func credentials(auth string) (string, string) {
-	creds := strings.Split(auth, ":")
-	utils.Assert(len(creds) == 2, "unexpected number of credentials")
-	return creds[0], creds[1]
-}
-
-type WriteCounter struct {
-	BytesTransferred uint64
-	TotalBytes       uint64
-}
-
-var writtenBytes = 0
-
-func (wc *WriteCounter) Write(p []byte) (int, error) {
-	n := len(p)
-	wc.BytesTransferred += uint64(n)
-	writtenBytes += n
-	if writtenBytes > 2000000 {
-		wc.PrintProgress()
-		writtenBytes = 0
-	}
-	return n, nil
-}
-
-func (wc WriteCounter) PrintProgress() {
-	b := float64(wc.BytesTransferred)
-	fmt.Printf("\r%s", strings.Repeat(" ", 40)) // clear line
-	fmt.Printf("\rdownloading... %0.f MB complete ", b/1e6)
-	if wc.TotalBytes > 0 {
-		fmt.Printf("(%.0f%%)", b/float64(wc.TotalBytes)*100)
-	}
-}
@@ -0,0 +1,155 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"rgx/common/utils"
+)
+
+var artifact = bytes.Repeat([]byte("0123456789abcdef"), 256)
+
+func artifactSums(b []byte) []utils.Checksum {
+	sum := sha256.Sum256(b)
+	return []utils.Checksum{{Algorithm: "sha256", Hash: hex.EncodeToString(sum[:])}}
+}
+
+// rangeServer records the Range header of every request and answers with handler
+func rangeServer(t *testing.T, handler func(w http.ResponseWriter, r *http.Request)) (*httptest.Server, func() []string) {
+	var lock sync.Mutex
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		lock.Unlock()
+		handler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return ranges
+	}
+}
+
+// serveArtifact supports range requests as long as the client's validator is "v1"
+func serveArtifact(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("ETag", `"v1"`)
+	http.ServeContent(w, r, "artifact", time.Time{}, bytes.NewReader(artifact))
+}
+
+// partialDownload leaves behind what an interrupted download of targetFile would have
+func partialDownload(t *testing.T, targetFile string, content []byte) {
+	t.Helper()
+	tempFile := targetFile + ".rgxdownload"
+	if e := os.WriteFile(tempFile, content, 0644); e != nil {
+		t.Fatal(e)
+	}
+	if e := os.WriteFile(validatorFile(tempFile), []byte(`"v1"`), 0644); e != nil {
+		t.Fatal(e)
+	}
+}
+
+func checkDownload(t *testing.T, targetFile string, ranges []string, want ...string) {
+	t.Helper()
+	b, e := os.ReadFile(targetFile)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !bytes.Equal(b, artifact) {
+		t.Errorf("downloaded %d bytes that differ from the artifact", len(b))
+	}
+	if strings.Join(ranges, ",") != strings.Join(want, ",") {
+		t.Errorf("got requests with ranges %q, want %q", ranges, want)
+	}
+	for _, f := range []string{targetFile + ".rgxdownload", validatorFile(targetFile + ".rgxdownload")} {
+		if utils.Exists(f) {
+			t.Errorf("%s was left behind", filepath.Base(f))
+		}
+	}
+}
+
+func TestDownloadResumes(t *testing.T) {
+	srv, ranges := rangeServer(t, serveArtifact)
+	target := filepath.Join(t.TempDir(), "artifact")
+	partialDownload(t, target, artifact[:1000])
+	if e := Download(context.Background(), testConfig(), srv.URL, target, artifactSums(artifact)); e != nil {
+		t.Fatal(e)
+	}
+	checkDownload(t, target, ranges(), "bytes=1000-")
+}
+
+func TestDownloadRestartsWhenTheRangeIsRejected(t *testing.T) {
+	srv, ranges := rangeServer(t, serveArtifact)
+	target := filepath.Join(t.TempDir(), "artifact")
+	// longer than the artifact, so the server answers 416
+	partialDownload(t, target, append(artifact, "more"...))
+	if e := Download(context.Background(), testConfig(), srv.URL, target, artifactSums(artifact)); e != nil {
+		t.Fatal(e)
+	}
+	checkDownload(t, target, ranges(), fmt.Sprintf("bytes=%d-", len(artifact)+4), "")
+}
+
+func TestDownloadRestartsWhenTheServerIgnoresTheRange(t *testing.T) {
+	srv, ranges := rangeServer(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(artifact)
+	})
+	target := filepath.Join(t.TempDir(), "artifact")
+	partialDownload(t, target, []byte("stale"))
+	if e := Download(context.Background(), testConfig(), srv.URL, target, artifactSums(artifact)); e != nil {
+		t.Fatal(e)
+	}
+	checkDownload(t, target, ranges(), "bytes=5-")
+}
+
+func TestDownloadRestartsWhenTheServerSendsAnotherRange(t *testing.T) {
+	srv, ranges := rangeServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") == "" {
+			_, _ = w.Write(artifact)
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(artifact)-1, len(artifact)))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(artifact)
+	})
+	target := filepath.Join(t.TempDir(), "artifact")
+	partialDownload(t, target, artifact[:1000])
+	if e := Download(context.Background(), testConfig(), srv.URL, target, artifactSums(artifact)); e != nil {
+		t.Fatal(e)
+	}
+	checkDownload(t, target, ranges(), "bytes=1000-", "")
+}
+
+func TestDownloadRestartsWhenTheResumedFileIsCorrupt(t *testing.T) {
+	srv, ranges := rangeServer(t, serveArtifact)
+	target := filepath.Join(t.TempDir(), "artifact")
+	partialDownload(t, target, bytes.Repeat([]byte("x"), 1000))
+	if e := Download(context.Background(), testConfig(), srv.URL, target, artifactSums(artifact)); e != nil {
+		t.Fatal(e)
+	}
+	checkDownload(t, target, ranges(), "bytes=1000-", "")
+}
+
+func TestDownloadReportsAChecksumMismatch(t *testing.T) {
+	srv, ranges := rangeServer(t, serveArtifact)
+	target := filepath.Join(t.TempDir(), "artifact")
+	e := Download(context.Background(), testConfig(), srv.URL, target, artifactSums([]byte("other")))
+	if !errors.Is(e, utils.ErrChecksumMismatch) {
+		t.Errorf("got %v, want ErrChecksumMismatch", e)
+	}
+	if len(ranges()) != 1 {
+		t.Errorf("got %d requests, want 1", len(ranges()))
+	}
+}
@@ -0,0 +1,77 @@
+package http
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"rgx/common/log"
+	"rgx/common/utils"
+)
+
+// validatorFile records the ETag or Last-Modified date of a partial download, which the server
+// must still match for the download to be resumed
+func validatorFile(tempFile string) string {
+	return tempFile + ".validator"
+}
+
+// resumeOffset returns the size of a partial download and its validator, or 0 if there is
+// nothing to resume
+func resumeOffset(tempFile string) (int64, string) {
+	info, e := os.Stat(tempFile)
+	if e != nil || info.Size() == 0 {
+		return 0, ""
+	}
+	b, e := os.ReadFile(validatorFile(tempFile))
+	validator := strings.TrimSpace(string(b))
+	if e != nil || validator == "" {
+		return 0, ""
+	}
+	return info.Size(), validator
+}
+
+// saveValidator is true if a download that fails part way through could be resumed later
+func saveValidator(tempFile string, resp *http.Response) bool {
+	if resp.StatusCode != http.StatusPartialContent && resp.Header.Get("Accept-Ranges") != "bytes" {
+		return false
+	}
+	// weak ETags can't be used for range requests
+	validator := resp.Header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = resp.Header.Get("Last-Modified")
+	}
+	if validator == "" {
+		return false
+	}
+	if e := os.WriteFile(validatorFile(tempFile), []byte(validator), 0664); e != nil {
+		log.Debug("could not write %s: %s", validatorFile(tempFile), e.Error())
+		return false
+	}
+	return true
+}
+
+func removeValidator(tempFile string) {
+	f := validatorFile(tempFile)
+	if !utils.Exists(f) {
+		return
+	}
+	if e := os.Remove(f); e != nil {
+		log.Warn("could not remove %s: %s", f, e.Error())
+	}
+}
+
+// rangeStart reads the first byte position from a Content-Range header such as
+// "bytes 1000-1999/2000", or returns -1
+func rangeStart(resp *http.Response) int64 {
+	r, ok := strings.CutPrefix(resp.Header.Get("Content-Range"), "bytes ")
+	if !ok {
+		return -1
+	}
+	start, _, _ := strings.Cut(r, "-")
+	n, e := strconv.ParseInt(start, 10, 64)
+	if e != nil {
+		return -1
+	}
+	return n
+}
@@ -0,0 +1,133 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"rgx/common/log"
+	"rgx/common/utils"
+)
+
+const (
+	maxRetryDelay = 30 * time.Second
+	// maxRetryAfter caps how long a Retry-After header can make rgx wait
+	maxRetryAfter = 5 * time.Minute
+)
+
+// statusError is returned for a response with an unexpected status code, and wraps one of
+// ErrNotFound, ErrServerUnavailable or ErrBadResponse
+type statusError struct {
+	url        string
+	code       int
+	status     string
+	retryAfter time.Duration
+	class      error
+}
+
+func (e *statusError) Error() string {
+	return e.class.Error() + ": " + e.url + ": " + e.status
+}
+
+func (e *statusError) Unwrap() error {
+	return e.class
+}
+
+// requestError is returned when a request fails before a status code arrives, or while the
+// body is read. It wraps ErrServerUnavailable, and transient is set for the failures that are
+// worth retrying.
+type requestError struct {
+	err       error
+	transient bool
+}
+
+func (e *requestError) Error() string {
+	return ErrServerUnavailable.Error() + ": " + e.err.Error()
+}
+
+func (e *requestError) Unwrap() error {
+	return ErrServerUnavailable
+}
+
+// transient is true for timeouts, connections that were reset or refused, and bodies that
+// ended early. DNS, TLS and malformed URL errors won't go away by retrying.
+func transient(e error) bool {
+	var ne net.Error
+	if errors.As(e, &ne) && ne.Timeout() {
+		return true
+	}
+	return errors.Is(e, syscall.ECONNRESET) || errors.Is(e, syscall.ECONNREFUSED) ||
+		errors.Is(e, io.ErrUnexpectedEOF)
+}
+
+// retryable is true for failures that may go away by themselves: timeouts, dropped
+// connections, rate limiting and server errors
+func retryable(e error) bool {
+	if errors.Is(e, context.Canceled) || errors.Is(e, context.DeadlineExceeded) {
+		return false
+	}
+	var se *statusError
+	if errors.As(e, &se) {
+		return se.code == http.StatusRequestTimeout || se.code == http.StatusTooManyRequests || se.code >= 500
+	}
+	var re *requestError
+	return errors.As(e, &re) && re.transient
+}
+
+// withRetry calls fn until it succeeds, fails with an error that is not retryable, or has
+// been retried http_retries times
+func withRetry(ctx context.Context, config *utils.RgxConfig, url string, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		e := fn()
+		if e == nil || !retryable(e) || attempt >= config.HttpRetries {
+			return e
+		}
+		delay := backoff(config.HttpRetryDelay, attempt)
+		var se *statusError
+		if errors.As(e, &se) && se.retryAfter > 0 {
+			delay = min(se.retryAfter, maxRetryAfter)
+		}
+		log.Debug("retrying %s in %s (retry %d of %d): %s", url, delay.Round(time.Millisecond),
+			attempt+1, config.HttpRetries, e.Error())
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// backoff doubles http_retry_delay with each attempt, and picks a random delay between half
+// and all of that so that clients that failed together don't retry together
+func backoff(delay time.Duration, attempt int) time.Duration {
+	if delay <= 0 {
+		return 0
+	}
+	// the shift is only safe when its result stays below the cap
+	d := maxRetryDelay
+	if attempt < 63 && delay <= maxRetryDelay>>attempt {
+		d = delay << attempt
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// retryAfter reads a Retry-After header, given either in seconds or as a date
+func retryAfter(resp *http.Response) time.Duration {
+	h := resp.Header.Get("Retry-After")
+	if h == "" {
+		return 0
+	}
+	if s, e := strconv.Atoi(h); e == nil && s >= 0 {
+		return time.Duration(s) * time.Second
+	}
+	if t, e := http.ParseTime(h); e == nil {
+		return time.Until(t)
+	}
+	return 0
+}
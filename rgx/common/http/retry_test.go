@@ -0,0 +1,171 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"rgx/common/utils"
+)
+
+func testConfig() *utils.RgxConfig {
+	return &utils.RgxConfig{HttpTimeout: 5 * time.Second, HttpRetries: 3, HttpRetryDelay: time.Millisecond}
+}
+
+// countingServer answers each request with the next of responses, repeating the last one
+func countingServer(t *testing.T, responses ...func(w http.ResponseWriter)) (*httptest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(calls.Add(1))
+		responses[min(n, len(responses))-1](w)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func status(code int, headers ...string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		for i := 0; i+1 < len(headers); i += 2 {
+			w.Header().Set(headers[i], headers[i+1])
+		}
+		w.WriteHeader(code)
+		_, _ = io.WriteString(w, http.StatusText(code))
+	}
+}
+
+func TestGetTextRetriesServerErrors(t *testing.T) {
+	srv, calls := countingServer(t, status(503), status(200))
+	resp, e := GetText(context.Background(), testConfig(), srv.URL)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if resp.Text != "OK" || calls.Load() != 2 {
+		t.Errorf("got %q after %d requests, want OK after 2", resp.Text, calls.Load())
+	}
+}
+
+func TestGetTextGivesUpAfterRetries(t *testing.T) {
+	srv, calls := countingServer(t, status(500))
+	_, e := GetText(context.Background(), testConfig(), srv.URL)
+	if !errors.Is(e, ErrServerUnavailable) {
+		t.Errorf("got %v, want ErrServerUnavailable", e)
+	}
+	if calls.Load() != 4 {
+		t.Errorf("got %d requests, want 4", calls.Load())
+	}
+}
+
+func TestGetTextHonoursRetryAfter(t *testing.T) {
+	srv, calls := countingServer(t, status(429, "Retry-After", "1"), status(200))
+	start := time.Now()
+	if _, e := GetText(context.Background(), testConfig(), srv.URL); e != nil {
+		t.Fatal(e)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, want at least 1s", elapsed)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("got %d requests, want 2", calls.Load())
+	}
+}
+
+func TestGetTextDoesNotRetryNotFound(t *testing.T) {
+	srv, calls := countingServer(t, status(404), status(200))
+	_, e := GetText(context.Background(), testConfig(), srv.URL)
+	if !errors.Is(e, ErrNotFound) {
+		t.Errorf("got %v, want ErrNotFound", e)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("got %d requests, want 1", calls.Load())
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		header   string
+		min, max time.Duration
+	}{
+		{"", 0, 0},
+		{"120", 120 * time.Second, 120 * time.Second},
+		{"-1", 0, 0},
+		{"soon", 0, 0},
+		{time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), 58 * time.Second, time.Minute},
+	}
+	for _, test := range tests {
+		resp := &http.Response{Header: http.Header{}}
+		if test.header != "" {
+			resp.Header.Set("Retry-After", test.header)
+		}
+		if got := retryAfter(resp); got < test.min || got > test.max {
+			t.Errorf("retryAfter(%q) = %s, want between %s and %s", test.header, got, test.min, test.max)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		delay    time.Duration
+		attempt  int
+		min, max time.Duration
+	}{
+		{0, 3, 0, 0},
+		{-time.Second, 0, 0, 0},
+		{time.Second, 0, 500 * time.Millisecond, time.Second},
+		{time.Second, 2, 2 * time.Second, 4 * time.Second},
+		{time.Second, 10, maxRetryDelay / 2, maxRetryDelay},
+		{time.Second, 40, maxRetryDelay / 2, maxRetryDelay},
+		{time.Second, 100, maxRetryDelay / 2, maxRetryDelay},
+		{time.Hour, 0, maxRetryDelay / 2, maxRetryDelay},
+	}
+	for _, test := range tests {
+		if got := backoff(test.delay, test.attempt); got < test.min || got > test.max {
+			t.Errorf("backoff(%s, %d) = %s, want between %s and %s", test.delay, test.attempt, got, test.min, test.max)
+		}
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		e    error
+		want bool
+	}{
+		{"timeout", &url.Error{Op: "Get", URL: "u", Err: timeoutError{}}, true},
+		{"connection reset", &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, true},
+		{"connection refused", &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, true},
+		{"truncated body", io.ErrUnexpectedEOF, true},
+		{"dns", &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "nowhere"}}, false},
+		{"bad url", &url.Error{Op: "Get", URL: "::", Err: errors.New("missing protocol scheme")}, false},
+		{"tls", fmt.Errorf("tls: failed to verify certificate"), false},
+	}
+	for _, test := range tests {
+		e := failedRequest(context.Background(), test.e)
+		if !errors.Is(e, ErrServerUnavailable) {
+			t.Errorf("%s: %v does not wrap ErrServerUnavailable", test.name, e)
+		}
+		if got := retryable(e); got != test.want {
+			t.Errorf("%s: retryable = %v, want %v", test.name, got, test.want)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if e := failedRequest(ctx, io.ErrUnexpectedEOF); retryable(e) || !errors.Is(e, context.Canceled) {
+		t.Errorf("a cancelled request returned %v", e)
+	}
+}
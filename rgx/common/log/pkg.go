@@ -14,7 +14,7 @@ const (
 	info
 )
 
-func isTerminal() bool {
+func IsTerminal() bool {
 	fileInfo, e := os.Stdout.Stat()
 	if e != nil {
 		return false
@@ -24,7 +24,7 @@ func isTerminal() bool {
 
 func canShowColor() bool {
 	// see https://no-color.org/
-	return isTerminal() && os.Getenv("RGX_COLOR_LOGS") == "1"
+	return IsTerminal() && os.Getenv("RGX_COLOR_LOGS") == "1"
 }
 
 //goland:noinspection GoUnusedConst
@@ -82,7 +82,17 @@ func toColor(level string) string {
 	return color(fgDefault, false)
 }
 
+// interrupt is called before each log line, e.g. to erase progress bars
+var interrupt func()
+
+func SetInterrupt(fn func()) {
+	interrupt = fn
+}
+
 func log(level, msg string) {
+	if interrupt != nil {
+		interrupt()
+	}
 	if colorizeOutput {
 		fmt.Printf("%s[%s %s] %s%s\n", toColor(level), time.Now().Format(tsFormat), level, msg, ansiReset)
 	} else {
@@ -0,0 +1,118 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"rgx/common/log"
+)
+
+const (
+	barWidth    = 30
+	redrawEvery = 100 * time.Millisecond
+	labelWidth  = 28
+	// eraseLine moves the cursor up a line and clears it
+	eraseLine = "\x1b[1A\x1b[2K"
+)
+
+// bars draws one line per running task below the log output, and redraws them in place
+type bars struct {
+	mu       sync.Mutex
+	out      io.Writer
+	tasks    []*bar
+	drawn    int
+	lastDraw time.Time
+}
+
+type bar struct {
+	b       *bars
+	label   string
+	total   int64
+	done    int64
+	started time.Time
+}
+
+func newBars(out io.Writer) *bars {
+	b := &bars{out: out}
+	// log lines go above the bars, which are drawn again with the next update
+	log.SetInterrupt(func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.erase()
+	})
+	return b
+}
+
+func (b *bars) Start(label string, total int64) Tracker {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := &bar{b: b, label: label, total: total, started: time.Now()}
+	b.tasks = append(b.tasks, t)
+	return t
+}
+
+func (t *bar) Add(n int64) {
+	b := t.b
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t.done += n
+	if time.Since(b.lastDraw) >= redrawEvery {
+		b.draw()
+	}
+}
+
+func (t *bar) Done() {
+	b := t.b
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.erase()
+	for i, x := range b.tasks {
+		if x == t {
+			b.tasks = append(b.tasks[:i], b.tasks[i+1:]...)
+			break
+		}
+	}
+	if elapsed := time.Since(t.started); elapsed >= showAfter {
+		_, _ = fmt.Fprintf(b.out, "%s  %s in %s\n", t.paddedLabel(), FormatBytes(t.done), elapsed.Round(100*time.Millisecond))
+	}
+	b.draw()
+}
+
+func (b *bars) erase() {
+	_, _ = fmt.Fprint(b.out, strings.Repeat(eraseLine, b.drawn))
+	b.drawn = 0
+}
+
+func (b *bars) draw() {
+	b.erase()
+	for _, t := range b.tasks {
+		if time.Since(t.started) < showAfter {
+			continue
+		}
+		_, _ = fmt.Fprintln(b.out, t.line())
+		b.drawn++
+	}
+	b.lastDraw = time.Now()
+}
+
+func (t *bar) paddedLabel() string {
+	l := t.label
+	if len(l) > labelWidth {
+		l = l[:labelWidth-3] + "..."
+	}
+	return fmt.Sprintf("%-*s", labelWidth, l)
+}
+
+func (t *bar) line() string {
+	var graph string
+	if t.total > 0 {
+		filled := int(percent(t.done, t.total) / 100 * barWidth)
+		graph = fmt.Sprintf("[%s%s] %3.0f%%", strings.Repeat("=", filled), strings.Repeat(" ", barWidth-filled), percent(t.done, t.total))
+	} else {
+		graph = fmt.Sprintf("[%s]     ", strings.Repeat("?", barWidth))
+	}
+	return t.paddedLabel() + " " + graph + "  " + status(t.done, t.total, time.Since(t.started))
+}
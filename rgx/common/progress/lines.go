@@ -0,0 +1,52 @@
+package progress
+
+import (
+	"sync"
+	"time"
+
+	"rgx/common/log"
+)
+
+// lines logs the progress of each task every interval, for output that isn't a terminal
+type lines struct {
+	interval time.Duration
+}
+
+type line struct {
+	mu       sync.Mutex
+	interval time.Duration
+	label    string
+	total    int64
+	done     int64
+	started  time.Time
+	lastLog  time.Time
+}
+
+func (l lines) Start(label string, total int64) Tracker {
+	now := time.Now()
+	return &line{interval: l.interval, label: label, total: total, started: now, lastLog: now}
+}
+
+func (t *line) Add(n int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.done += n
+	if time.Since(t.lastLog) < t.interval {
+		return
+	}
+	t.lastLog = time.Now()
+	if t.total > 0 {
+		log.Info("%s: %.0f%%  %s", t.label, percent(t.done, t.total), status(t.done, t.total, time.Since(t.started)))
+	} else {
+		log.Info("%s: %s", t.label, status(t.done, t.total, time.Since(t.started)))
+	}
+}
+
+func (t *line) Done() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// quick tasks were never reported, so their end isn't either
+	if t.lastLog.After(t.started) {
+		log.Info("%s: done, %s in %s", t.label, FormatBytes(t.done), time.Since(t.started).Round(time.Second))
+	}
+}
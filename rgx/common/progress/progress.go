@@ -0,0 +1,110 @@
+// Package progress shows how far long-running tasks such as downloads and extractions have got:
+// as bars on a terminal, as periodic log lines otherwise, or not at all.
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"rgx/common/log"
+)
+
+// Tracker follows one task, measured in bytes
+type Tracker interface {
+	Add(n int64)
+	Done()
+}
+
+type Reporter interface {
+	// Start begins tracking a task of total bytes, or of an unknown size if total is 0
+	Start(label string, total int64) Tracker
+}
+
+// tasks are only shown once they have run for this long, so that quick ones stay silent
+const showAfter = 500 * time.Millisecond
+
+var reporter Reporter = none{}
+
+// Configure shows bars on a terminal and log lines otherwise, or nothing at all if quiet
+func Configure(quiet bool) {
+	switch {
+	case quiet:
+		reporter = none{}
+	case log.IsTerminal() && supportsAnsi():
+		reporter = newBars(os.Stdout)
+	default:
+		reporter = lines{interval: 5 * time.Second}
+	}
+}
+
+func SetReporter(r Reporter) {
+	reporter = r
+}
+
+func Start(label string, total int64) Tracker {
+	return reporter.Start(label, total)
+}
+
+// Reader reports the bytes read from r to t
+func Reader(r io.Reader, t Tracker) io.Reader {
+	return &countingReader{r, t}
+}
+
+type countingReader struct {
+	r io.Reader
+	t Tracker
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, e := c.r.Read(p)
+	c.t.Add(int64(n))
+	return n, e
+}
+
+func supportsAnsi() bool {
+	// the classic windows console doesn't understand escape sequences, Windows Terminal does
+	return os.Getenv("OS") != "Windows_NT" || os.Getenv("WT_SESSION") != ""
+}
+
+type none struct{}
+
+func (none) Start(string, int64) Tracker { return none{} }
+func (none) Add(int64)                   {}
+func (none) Done()                       {}
+
+// status describes a task, e.g. "12.3 MB / 27.0 MB  3.1 MB/s  ETA 5s"
+func status(done, total int64, elapsed time.Duration) string {
+	rate := float64(done) / max(elapsed.Seconds(), 0.001)
+	s := FormatBytes(done)
+	if total > 0 {
+		s += " / " + FormatBytes(total)
+	}
+	s += "  " + FormatBytes(int64(rate)) + "/s"
+	if total > 0 && rate > 0 && done < total {
+		eta := time.Duration(float64(total-done) / rate * float64(time.Second))
+		s += "  ETA " + eta.Round(time.Second).String()
+	}
+	return s
+}
+
+func percent(done, total int64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return min(float64(done)/float64(total), 1) * 100
+}
+
+// FormatBytes formats n as a size with a decimal unit, e.g. 1.5 MB
+func FormatBytes(n int64) string {
+	switch {
+	case n >= 1e9:
+		return fmt.Sprintf("%.1f GB", float64(n)/1e9)
+	case n >= 1e6:
+		return fmt.Sprintf("%.1f MB", float64(n)/1e6)
+	case n >= 1e3:
+		return fmt.Sprintf("%.0f kB", float64(n)/1e3)
+	}
+	return fmt.Sprintf("%d B", n)
+}
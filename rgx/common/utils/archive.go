@@ -3,169 +3,550 @@ package utils
 import (
 	"archive/tar"
 	"archive/zip"
-	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 
 	"rgx/common/log"
+	"rgx/common/progress"
 )
 
-func Extract(archiveName, targetDir string) bool {
+// ExtractOptions select which entries of an archive are extracted and where they go
+type ExtractOptions struct {
+	// StripComponents removes that many leading directories from every entry, entries that
+	// are not nested deep enough are skipped
+	StripComponents int
+	// Include and Exclude are path.Match patterns for entry paths after stripping. A pattern
+	// that matches a directory applies to everything in it. If Include is not empty, only
+	// entries matching one of its patterns are extracted, Exclude wins over Include.
+	Include []string
+	Exclude []string
+}
+
+func (o ExtractOptions) validate() error {
+	if o.StripComponents < 0 {
+		return fmt.Errorf("%w: strip_components must not be negative", ErrExtract)
+	}
+	for _, p := range append(append([]string{}, o.Include...), o.Exclude...) {
+		if _, e := path.Match(p, ""); e != nil {
+			return fmt.Errorf("%w: invalid pattern %q", ErrExtract, p)
+		}
+	}
+	return nil
+}
+
+// entryPath returns where an entry goes relative to the target directory, and false if the
+// entry is not extracted. Absolute names are returned unchanged for safeJoin to reject.
+func (o ExtractOptions) entryPath(name string) (string, bool) {
+	if isAbsolute(name) {
+		return name, true
+	}
+	var parts []string
+	for _, part := range strings.Split(strings.ReplaceAll(name, "\\", "/"), "/") {
+		if part != "" && part != "." {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) <= o.StripComponents {
+		return "", false
+	}
+	p := strings.Join(parts[o.StripComponents:], "/")
+	if len(o.Include) > 0 && !matchesAny(o.Include, p) {
+		return "", false
+	}
+	if matchesAny(o.Exclude, p) {
+		return "", false
+	}
+	return p, true
+}
+
+// matchesAny reports whether p or one of its parent directories matches one of patterns
+func matchesAny(patterns []string, p string) bool {
+	for dir := p; dir != "." && dir != "/"; dir = path.Dir(dir) {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, dir); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Extract unpacks archiveName into targetDir, which it creates if needed. If it fails or ctx
+// is cancelled, whatever it added to targetDir is removed again, and so are the directories it
+// created.
+func Extract(ctx context.Context, archiveName, targetDir string, opts ExtractOptions) (err error) {
 
 	log.Trace("starting to decompress %s ...", archiveName)
+	if e := opts.validate(); e != nil {
+		return e
+	}
 
-	if strings.HasSuffix(archiveName, ".tar.gz") {
-		// tar zxf the downloaded xyz.tar.gz to targetDir
-		tgz, e := os.Open(archiveName)
-		ErrCheck(e)
-		untar(tgz, targetDir)
-		e = tgz.Close()
-		ErrCheck(e)
-	} else if strings.HasSuffix(archiveName, ".zip") {
+	before := dirEntries(targetDir)
+	created := topMissingDir(targetDir)
+	defer func() {
+		if err == nil {
+			return
+		}
+		removeAdded(targetDir, before)
+		if created != "" {
+			log.Debug("removing %s, created by the failed extraction", created)
+			if e := os.RemoveAll(created); e != nil {
+				log.Warn("could not remove %s: %s", created, e.Error())
+			}
+		}
+	}()
+
+	format, e := detectFormat(archiveName)
+	if e != nil {
+		return e
+	}
+	log.Debug("%s is a %s archive", archiveName, format)
+
+	if format == formatZip {
 		// unzip the archive, typically on windows
-		e := unzip(archiveName, targetDir)
-		ErrCheck(e)
+		e := unzip(ctx, archiveName, targetDir, opts)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if e != nil {
+			return fmt.Errorf("%w: %s: %s", ErrExtract, archiveName, e.Error())
+		}
+	} else {
+		// tar xf the downloaded archive to targetDir
+		f, e := os.Open(archiveName)
+		if e != nil {
+			return fmt.Errorf("%w: %s", ErrExtract, e.Error())
+		}
+		var size int64
+		if info, e := f.Stat(); e == nil {
+			size = info.Size()
+		}
+		// progress is measured on the compressed stream, whose size is known up front
+		tracker := progress.Start("extracting "+filepath.Base(archiveName), size)
+		stream, e := decompress(format, &contextReader{ctx, progress.Reader(f, tracker)})
+		missing := make(map[string][]string)
+		if e == nil {
+			e = untar(stream, targetDir, opts, missing)
+			if c, ok := stream.(io.Closer); ok {
+				_ = c.Close()
+			}
+		}
+		tracker.Done()
+		closeErr := f.Close()
+		if e == nil && len(missing) > 0 {
+			e = copyLinkSources(ctx, archiveName, format, targetDir, missing)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if e != nil {
+			return fmt.Errorf("%w: %s: %s", ErrExtract, archiveName, e.Error())
+		}
+		if closeErr != nil {
+			log.Debug("could not close %s: %s", archiveName, closeErr.Error())
+		}
 	}
 	if !Exists(targetDir) {
 		log.Trace("tried to extract arhive to %s, but it doesnt exist ", targetDir)
-		return false
+		return fmt.Errorf("%w: %s was not extracted to %s", ErrExtract, archiveName, targetDir)
 	} else {
 		log.Trace("extracted archive to %s", targetDir)
-		return true
+		return nil
 	}
 }
 
-func untar(gzipStream io.Reader, targetDir string) {
-	cwd := getwd()
-	uncompressedStream, err := gzip.NewReader(gzipStream)
-	if err != nil {
-		log.Fatal("extract: failed to open gzip stream")
+// contextReader fails reads once ctx is cancelled, which stops a long copy part way through
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if e := c.ctx.Err(); e != nil {
+		return 0, e
+	}
+	return c.r.Read(p)
+}
+
+func dirEntries(dir string) map[string]bool {
+	names := make(map[string]bool)
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		names[entry.Name()] = true
+	}
+	return names
+}
+
+// topMissingDir returns the topmost directory of dir and its parents that does not exist, or
+// "" if dir exists
+func topMissingDir(dir string) string {
+	dir, e := filepath.Abs(dir)
+	if e != nil || Exists(dir) {
+		return ""
+	}
+	for parent := filepath.Dir(dir); parent != dir && !Exists(parent); parent = filepath.Dir(dir) {
+		dir = parent
 	}
+	return dir
+}
 
-	tarReader := tar.NewReader(uncompressedStream)
+// removeAdded removes the entries of dir that are not in before
+func removeAdded(dir string, before map[string]bool) {
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if before[entry.Name()] {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		log.Debug("removing partially extracted %s", path)
+		if e := os.RemoveAll(path); e != nil {
+			log.Warn("could not remove %s: %s", path, e.Error())
+		}
+	}
+}
+
+// untar extracts an uncompressed tar stream. Entries that would end up outside targetDir,
+// directly or through a link, make the whole extraction fail. Hardlinks to entries that are
+// not extracted are added to missing, by the archive name of their source.
+func untar(stream io.Reader, targetDir string, opts ExtractOptions, missing map[string][]string) error {
+	root, err := filepath.Abs(targetDir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+	tarReader := tar.NewReader(stream)
 	for {
 		header, err := tarReader.Next()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			log.Fatal("extract: failed to open tar header: %s", err.Error())
+			return fmt.Errorf("failed to open tar header: %s", err.Error())
+		}
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			// pax records that apply to the whole archive, there is nothing to extract
+			continue
+		}
+
+		name, ok := opts.entryPath(header.Name)
+		if !ok {
+			log.Trace("skipping %s", header.Name)
+			continue
+		}
+		path, err := safeJoin(root, name)
+		if err != nil {
+			return err
+		}
+		if path == root {
+			continue
+		}
+		// an earlier symlink must not take the entry out of targetDir
+		if err := checkResolved(root, filepath.Dir(path), header.Name); err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeDir {
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return fmt.Errorf("failed to create parent directories: %s", err.Error())
+			}
 		}
 
 		switch header.Typeflag {
 		case tar.TypeDir:
-			if err := os.MkdirAll(filepath.Join(targetDir, header.Name), 0755); err != nil {
-				log.Fatal("extract: failed to create directory: %s", err.Error())
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return fmt.Errorf("failed to create directory: %s", err.Error())
 			}
-		case tar.TypeReg:
-			if err := os.MkdirAll(filepath.Join(targetDir, header.Name), 0755); err != nil {
-				log.Fatal("extract: failed to create parent directories: %s", err.Error())
+		case tar.TypeReg, tar.TypeRegA:
+			if err := extractFile(path, tarReader, header.Mode); err != nil {
+				return err
 			}
-			outFile, err := os.Create(filepath.Join(targetDir, header.Name))
-			if err != nil {
-				log.Fatal("extract: failed to create file: %s", err.Error())
+		case tar.TypeSymlink:
+			if isAbsolute(header.Linkname) {
+				return fmt.Errorf("illegal symlink: %s points to absolute path %s", header.Name, header.Linkname)
+			}
+			// the link is resolved from where it is extracted to, after stripping, and must stay
+			// inside even if its target does not exist yet
+			if _, err := safeJoin(root, filepath.Join(filepath.Dir(filepath.FromSlash(name)), header.Linkname)); err != nil {
+				return fmt.Errorf("illegal symlink: %s points outside of the target directory", header.Name)
 			}
-			if _, err := io.Copy(outFile, tarReader); err != nil {
-				log.Fatal("extract: failed to copy data: %s", err.Error())
+			if err := removeLink(path); err != nil {
+				return err
 			}
-			closeError := outFile.Close()
-			if closeError != nil {
-				log.Debug("error closing file")
+			if err := os.Symlink(header.Linkname, path); err != nil {
+				log.Error("extract: error creating symlink: %v", err)
+				continue
 			}
-			if header.Mode&0111 != 0 { // has executable bit set
-				e := os.Chmod(filepath.Join(targetDir, header.Name), os.FileMode(header.Mode))
-				if e != nil {
-					log.Warn("could not set permissions: %v", err)
-				}
+			// the target may run through other links, so check where it really ends up
+			if err := checkResolved(root, path, header.Name); err != nil {
+				_ = os.Remove(path)
+				return err
 			}
-		case tar.TypeSymlink:
-			if err := os.MkdirAll(filepath.Dir(filepath.Join(targetDir, header.Name)), 0755); err != nil {
-				log.Fatal("extract: failed to create parent directories: %s", err.Error())
+		case tar.TypeLink:
+			// hardlink names are relative to the root of the archive
+			linkname, ok := opts.entryPath(header.Linkname)
+			if !ok {
+				// exclude or strip_components dropped the source, copyLinkSources fills in its data
+				source := entryName(header.Linkname)
+				missing[source] = append(missing[source], path)
+				continue
 			}
-			err := os.Symlink(header.Linkname, filepath.Join(targetDir, header.Name))
+			source, err := safeJoin(root, linkname)
 			if err != nil {
-				log.Error("extract: error creating symlink: %v", err)
+				return fmt.Errorf("illegal hardlink: %s points outside of the target directory", header.Name)
+			}
+			if err := checkResolved(root, source, header.Name); err != nil {
+				return err
+			}
+			if err := removeLink(path); err != nil {
+				return err
+			}
+			if err := os.Link(source, path); err != nil {
+				return fmt.Errorf("failed to create hardlink %s: %s", header.Name, err.Error())
 			}
 		default:
-			log.Fatal("extract: unknown type: %v in %s", header.Typeflag, header.Name)
+			log.Warn("skipping %s in %s, unsupported type %q", header.Name, targetDir, header.Typeflag)
 		}
 	}
-	err = os.Chdir(cwd)
-	ErrCheck(err)
+	return nil
 }
 
-func unzip(src, dest string) error {
+// extractFile writes the data of a regular file to path, keeping its executable bits
+func extractFile(path string, r io.Reader, mode int64) error {
+	if err := removeLink(path); err != nil {
+		return err
+	}
+	outFile, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %s", err.Error())
+	}
+	if _, err := io.Copy(outFile, r); err != nil {
+		_ = outFile.Close()
+		return fmt.Errorf("failed to copy data: %s", err.Error())
+	}
+	closeError := outFile.Close()
+	if closeError != nil {
+		log.Debug("error closing file")
+	}
+	if mode&0111 != 0 { // has executable bit set
+		e := os.Chmod(path, os.FileMode(mode).Perm())
+		if e != nil {
+			log.Warn("could not set permissions: %v", e)
+		}
+	}
+	return nil
+}
+
+// copyLinkSources reads the archive once more and writes the data of the sources in missing to
+// the hardlinks that pointed to them. A source that is not a regular file in the archive is
+// skipped with a warning, and so are its links.
+func copyLinkSources(ctx context.Context, archiveName string, format archiveFormat, targetDir string, missing map[string][]string) error {
+	root, err := filepath.Abs(targetDir)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(archiveName)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	stream, err := decompress(format, &contextReader{ctx, f})
+	if err != nil {
+		return err
+	}
+	if c, ok := stream.(io.Closer); ok {
+		defer func() { _ = c.Close() }()
+	}
+	tarReader := tar.NewReader(stream)
+	for len(missing) > 0 {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to open tar header: %s", err.Error())
+		}
+		links, ok := missing[entryName(header.Name)]
+		if !ok || (header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA) {
+			continue
+		}
+		delete(missing, entryName(header.Name))
+		for i, link := range links {
+			if err := checkResolved(root, filepath.Dir(link), header.Name); err != nil {
+				return err
+			}
+			if i == 0 {
+				err = extractFile(link, tarReader, header.Mode)
+			} else if err = removeLink(link); err == nil {
+				err = os.Link(links[0], link)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to create hardlink %s: %s", link, err.Error())
+			}
+		}
+		log.Trace("copied %s to the hardlinks %v", header.Name, links)
+	}
+	for source, links := range missing {
+		log.Warn("skipping the hardlinks %v in %s, their source %s is not a file in the archive", links, targetDir, source)
+	}
+	return nil
+}
+
+// entryName normalizes the name of an archive entry, so that the name a hardlink gives its
+// source matches the source's own name
+func entryName(name string) string {
+	return path.Clean(strings.ReplaceAll(name, "\\", "/"))
+}
+
+// safeJoin joins name onto root and fails if the result is absolute in the archive or lies
+// outside of root
+func safeJoin(root, name string) (string, error) {
+	if isAbsolute(name) {
+		return "", fmt.Errorf("illegal file path: %s is absolute", name)
+	}
+	path := filepath.Join(root, name)
+	if !within(root, path) {
+		return "", fmt.Errorf("illegal file path: %s", name)
+	}
+	return path, nil
+}
+
+// isAbsolute also treats names with a drive letter or a leading backslash as absolute on every
+// platform, since an archive may be extracted on windows as well
+func isAbsolute(name string) bool {
+	if strings.HasPrefix(name, "\\") || len(name) >= 2 && name[1] == ':' && isLetter(name[0]) {
+		return true
+	}
+	return filepath.IsAbs(name) || strings.HasPrefix(name, "/") || filepath.VolumeName(name) != ""
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func within(root, path string) bool {
+	root = filepath.Clean(root)
+	return path == root || strings.HasPrefix(path, root+string(os.PathSeparator))
+}
+
+// checkResolved fails if path, after following the links that exist so far, is outside of
+// root. Parts of path that don't exist yet are checked at their nearest existing parent.
+func checkResolved(root, path, name string) error {
+	realRoot, e := filepath.EvalSymlinks(root)
+	if e != nil {
+		return nil
+	}
+	for within(root, path) {
+		real, e := filepath.EvalSymlinks(path)
+		if e == nil {
+			if !within(realRoot, real) {
+				return fmt.Errorf("illegal file path: %s resolves outside of the target directory", name)
+			}
+			return nil
+		}
+		if info, e := os.Lstat(path); e == nil && info.Mode()&os.ModeSymlink != 0 {
+			// a dangling link can't be written through, os.MkdirAll and removeLink refuse it
+			return nil
+		}
+		path = filepath.Dir(path)
+	}
+	return nil
+}
+
+// removeLink removes a link that an earlier entry created at path, so that the entry
+// replaces it instead of writing through it
+func removeLink(path string) error {
+	info, e := os.Lstat(path)
+	if e != nil || info.Mode()&os.ModeSymlink == 0 {
+		return nil
+	}
+	return os.Remove(path)
+}
+
+func unzip(ctx context.Context, src, dest string, opts ExtractOptions) error {
 	r, err := zip.OpenReader(src)
 	if err != nil {
 		return err
 	}
 	defer func() {
 		if err := r.Close(); err != nil {
-			panic(err)
+			log.Debug("could not close %s: %s", src, err.Error())
 		}
 	}()
 
 	err = os.MkdirAll(dest, 0755)
-	ErrCheck(err)
+	if err != nil {
+		return err
+	}
+	root, err := filepath.Abs(dest)
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	for _, f := range r.File {
+		size += int64(f.CompressedSize64)
+	}
+	tracker := progress.Start("extracting "+filepath.Base(src), size)
+	defer tracker.Done()
 
 	// Closure to address file descriptors issue with all the deferred .Close() methods
 	extractAndWriteFile := func(f *zip.File) error {
+		name, ok := opts.entryPath(f.Name)
+		if !ok {
+			log.Trace("skipping %s", f.Name)
+			return nil
+		}
 		rc, err := f.Open()
 		if err != nil {
 			return err
 		}
 		defer func() {
 			if err := rc.Close(); err != nil {
-				panic(err)
+				log.Debug("could not close %s: %s", f.Name, err.Error())
 			}
 		}()
 
-		path := filepath.Join(dest, f.Name)
-
 		// Check for ZipSlip (Directory traversal)
-		if !strings.HasPrefix(path, filepath.Clean(dest)+string(os.PathSeparator)) {
-			return fmt.Errorf("illegal file path: %s", path)
+		path, err := safeJoin(root, name)
+		if err != nil {
+			return err
 		}
 
 		if f.FileInfo().IsDir() {
-			err := os.MkdirAll(path, f.Mode())
-			ErrCheck(err)
+			return os.MkdirAll(path, f.Mode())
 		} else {
 			err := os.MkdirAll(filepath.Dir(path), f.Mode())
-			ErrCheck(err)
-			f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
 			if err != nil {
 				return err
 			}
-			defer func() {
-				if err := f.Close(); err != nil {
-					panic(err)
-				}
-			}()
-
-			_, err = io.Copy(f, rc)
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
 			if err != nil {
 				return err
 			}
+			_, err = io.Copy(f, &contextReader{ctx, rc})
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			return err
 		}
-		return nil
 	}
 
 	for _, f := range r.File {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		err := extractAndWriteFile(f)
 		if err != nil {
 			return err
 		}
+		tracker.Add(int64(f.CompressedSize64))
 	}
 
 	return nil
 }
-
-func getwd() string {
-	cwd, e := os.Getwd()
-	ErrCheck(e)
-	return cwd
-}
@@ -0,0 +1,384 @@
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// entry is one member of a test archive, Body is the content of regular files
+type entry struct {
+	Name     string
+	Type     byte
+	Linkname string
+	Body     string
+}
+
+func writeTar(t *testing.T, entries []entry) string {
+	t.Helper()
+	var b bytes.Buffer
+	w := tar.NewWriter(&b)
+	for _, en := range entries {
+		h := &tar.Header{Name: en.Name, Typeflag: en.Type, Linkname: en.Linkname, Mode: 0644, Size: int64(len(en.Body))}
+		switch en.Type {
+		case tar.TypeDir:
+			h.Mode = 0755
+		case tar.TypeXGlobalHeader:
+			h = &tar.Header{Typeflag: en.Type, PAXRecords: map[string]string{"comment": "global"}}
+		}
+		if e := w.WriteHeader(h); e != nil {
+			t.Fatal(e)
+		}
+		if _, e := w.Write([]byte(en.Body)); e != nil {
+			t.Fatal(e)
+		}
+	}
+	if e := w.Close(); e != nil {
+		t.Fatal(e)
+	}
+	archive := filepath.Join(t.TempDir(), "test.tar")
+	if e := os.WriteFile(archive, b.Bytes(), 0644); e != nil {
+		t.Fatal(e)
+	}
+	return archive
+}
+
+// extractDirs returns the directory extracted to and its parent, which must stay untouched
+func extractDirs(t *testing.T) (string, string) {
+	parent := t.TempDir()
+	root := filepath.Join(parent, "root")
+	if e := os.Mkdir(root, 0755); e != nil {
+		t.Fatal(e)
+	}
+	return root, parent
+}
+
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, e := os.ReadDir(dir)
+	if e != nil {
+		t.Fatal(e)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestExtractRejectsEscapingEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+		opts    ExtractOptions
+	}{
+		{"parent traversal", []entry{{Name: "../evil", Type: tar.TypeReg, Body: "x"}}, ExtractOptions{}},
+		{"nested traversal", []entry{{Name: "a/../../evil", Type: tar.TypeReg, Body: "x"}}, ExtractOptions{}},
+		{"absolute path", []entry{{Name: "/tmp/evil", Type: tar.TypeReg, Body: "x"}}, ExtractOptions{}},
+		{"volume path", []entry{{Name: `C:\evil`, Type: tar.TypeReg, Body: "x"}}, ExtractOptions{}},
+		{"unc path", []entry{{Name: `\\server\share\evil`, Type: tar.TypeReg, Body: "x"}}, ExtractOptions{}},
+		{"symlink outside", []entry{{Name: "link", Type: tar.TypeSymlink, Linkname: "../../etc"}}, ExtractOptions{}},
+		{"dangling symlink outside", []entry{{Name: "link", Type: tar.TypeSymlink, Linkname: "../missing"}}, ExtractOptions{}},
+		{"absolute symlink", []entry{{Name: "link", Type: tar.TypeSymlink, Linkname: "/etc"}}, ExtractOptions{}},
+		{"write through symlink", []entry{
+			{Name: "link", Type: tar.TypeSymlink, Linkname: ".."},
+			{Name: "link/evil", Type: tar.TypeReg, Body: "x"},
+		}, ExtractOptions{}},
+		{"hardlink outside", []entry{{Name: "link", Type: tar.TypeLink, Linkname: "../secret"}}, ExtractOptions{}},
+		{"absolute hardlink", []entry{{Name: "link", Type: tar.TypeLink, Linkname: "/etc/passwd"}}, ExtractOptions{}},
+		{"stripped symlink outside", []entry{
+			{Name: "top/link", Type: tar.TypeSymlink, Linkname: "../evil"},
+		}, ExtractOptions{StripComponents: 1}},
+		{"stripped nested symlink outside", []entry{
+			{Name: "top/a/link", Type: tar.TypeSymlink, Linkname: "../../evil"},
+		}, ExtractOptions{StripComponents: 1}},
+		{"stripped hardlink outside", []entry{
+			{Name: "top/file", Type: tar.TypeReg, Body: "x"},
+			{Name: "top/link", Type: tar.TypeLink, Linkname: "top/../../secret"},
+		}, ExtractOptions{StripComponents: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, parent := extractDirs(t)
+			if e := os.WriteFile(filepath.Join(parent, "secret"), []byte("secret"), 0644); e != nil {
+				t.Fatal(e)
+			}
+			e := Extract(context.Background(), writeTar(t, tt.entries), root, tt.opts)
+			if e == nil {
+				t.Fatal("extracted a hostile archive")
+			}
+			if names := dirNames(t, parent); !slices.Equal(names, []string{"root", "secret"}) {
+				t.Errorf("wrote outside of the target directory: %v", names)
+			}
+			if names := dirNames(t, root); len(names) != 0 {
+				t.Errorf("left %v behind after failing", names)
+			}
+		})
+	}
+}
+
+func TestExtractRemovesCreatedDirectories(t *testing.T) {
+	_, parent := extractDirs(t)
+	archive := writeTar(t, []entry{
+		{Name: "file", Type: tar.TypeReg, Body: "x"},
+		{Name: "../evil", Type: tar.TypeReg, Body: "x"},
+	})
+	if e := Extract(context.Background(), archive, filepath.Join(parent, "new", "dir"), ExtractOptions{}); e == nil {
+		t.Fatal("extracted a hostile archive")
+	}
+	if names := dirNames(t, parent); !slices.Equal(names, []string{"root"}) {
+		t.Errorf("left %v behind after failing", names)
+	}
+}
+
+func TestExtractRejectsWritesThroughExistingLinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("creating symlinks needs privileges on windows")
+	}
+	root, parent := extractDirs(t)
+	outside := filepath.Join(parent, "outside")
+	if e := os.Mkdir(outside, 0755); e != nil {
+		t.Fatal(e)
+	}
+	if e := os.Symlink(outside, filepath.Join(root, "planted")); e != nil {
+		t.Fatal(e)
+	}
+	archive := writeTar(t, []entry{{Name: "planted/evil", Type: tar.TypeReg, Body: "x"}})
+	if e := Extract(context.Background(), archive, root, ExtractOptions{}); e == nil {
+		t.Fatal("wrote through a link that points outside of the target directory")
+	}
+	if names := dirNames(t, outside); len(names) != 0 {
+		t.Errorf("wrote %v outside of the target directory", names)
+	}
+}
+
+func TestExtractLinksInsideRoot(t *testing.T) {
+	root, _ := extractDirs(t)
+	archive := writeTar(t, []entry{
+		{Name: "top/bin/", Type: tar.TypeDir},
+		{Name: "top/bin/tool", Type: tar.TypeReg, Body: "tool"},
+		{Name: "top/bin/alias", Type: tar.TypeSymlink, Linkname: "tool"},
+		{Name: "top/current", Type: tar.TypeSymlink, Linkname: "bin"},
+		{Name: "top/bin/copy", Type: tar.TypeLink, Linkname: "top/bin/tool"},
+	})
+	if e := Extract(context.Background(), archive, root, ExtractOptions{StripComponents: 1}); e != nil {
+		t.Fatal(e)
+	}
+	for _, name := range []string{"bin/tool", "bin/alias", "bin/copy", "current/tool"} {
+		b, e := os.ReadFile(filepath.Join(root, name))
+		if e != nil || string(b) != "tool" {
+			t.Errorf("%s: %q, %v", name, b, e)
+		}
+	}
+}
+
+func TestExtractCopiesHardlinksToSkippedEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+		opts    ExtractOptions
+		want    map[string]string
+	}{
+		{"excluded source", []entry{
+			{Name: "top/bin/tool", Type: tar.TypeReg, Body: "tool"},
+			{Name: "top/bin/copy", Type: tar.TypeLink, Linkname: "top/bin/tool"},
+			{Name: "top/bin/other", Type: tar.TypeLink, Linkname: "./top/bin/tool"},
+		}, ExtractOptions{StripComponents: 1, Exclude: []string{"bin/tool"}},
+			map[string]string{"bin/copy": "tool", "bin/other": "tool"}},
+		{"stripped source", []entry{
+			{Name: "tool", Type: tar.TypeReg, Body: "tool"},
+			{Name: "top/copy", Type: tar.TypeLink, Linkname: "tool"},
+		}, ExtractOptions{StripComponents: 1}, map[string]string{"copy": "tool"}},
+		{"source outside the included entries", []entry{
+			{Name: "lib/data", Type: tar.TypeReg, Body: "data"},
+			{Name: "bin/data", Type: tar.TypeLink, Linkname: "lib/data"},
+			{Name: "bin/tool", Type: tar.TypeReg, Body: "tool"},
+		}, ExtractOptions{Include: []string{"bin"}}, map[string]string{"bin/data": "data", "bin/tool": "tool"}},
+		{"source that is not a file", []entry{
+			{Name: "top/dir/", Type: tar.TypeDir},
+			{Name: "top/bin/tool", Type: tar.TypeReg, Body: "tool"},
+			{Name: "top/bin/dir", Type: tar.TypeLink, Linkname: "top/dir"},
+			{Name: "top/bin/gone", Type: tar.TypeLink, Linkname: "top/missing"},
+		}, ExtractOptions{StripComponents: 1, Exclude: []string{"dir", "missing"}}, map[string]string{"bin/tool": "tool"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, _ := extractDirs(t)
+			if e := Extract(context.Background(), writeTar(t, tt.entries), root, tt.opts); e != nil {
+				t.Fatal(e)
+			}
+			var files []string
+			e := filepath.WalkDir(root, func(p string, d os.DirEntry, e error) error {
+				if e != nil || d.IsDir() {
+					return e
+				}
+				rel, _ := filepath.Rel(root, p)
+				files = append(files, filepath.ToSlash(rel))
+				b, e := os.ReadFile(p)
+				if e != nil {
+					return e
+				}
+				if string(b) != tt.want[filepath.ToSlash(rel)] {
+					t.Errorf("%s contains %q, want %q", rel, b, tt.want[filepath.ToSlash(rel)])
+				}
+				return nil
+			})
+			if e != nil {
+				t.Fatal(e)
+			}
+			if len(files) != len(tt.want) {
+				t.Errorf("extracted %v, want %v", files, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractSkipsGlobalHeaders(t *testing.T) {
+	root, _ := extractDirs(t)
+	archive := writeTar(t, []entry{
+		{Type: tar.TypeXGlobalHeader},
+		{Name: "file", Type: tar.TypeReg, Body: "x"},
+	})
+	if e := Extract(context.Background(), archive, root, ExtractOptions{}); e != nil {
+		t.Fatal(e)
+	}
+	if names := dirNames(t, root); !slices.Equal(names, []string{"file"}) {
+		t.Errorf("extracted %v", names)
+	}
+}
+
+// bzip2Tar is a tar with "file" containing "x", compressed by bzip2, which the standard
+// library can only decompress
+const bzip2Tar = "QlpoOTFBWSZTWX5t8rQAAG3bgMmAYABngAAEYyQeQAgIIABUNICMCaYaaBJFA002o0DQfVkOhB7eCEQ3cSI0zxQIYGKOJ2EVdBdPYiznWWNnfRmiJyJEQHRdyRThQkH5t8rQ"
+
+func fileTar(t *testing.T) []byte {
+	b, e := os.ReadFile(writeTar(t, []entry{{Name: "file", Type: tar.TypeReg, Body: "x"}}))
+	if e != nil {
+		t.Fatal(e)
+	}
+	return b
+}
+
+// compressed compresses the tar of fileTar with w
+func compressed(t *testing.T, newWriter func(io.Writer) (io.WriteCloser, error)) []byte {
+	var b bytes.Buffer
+	w, e := newWriter(&b)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if _, e := w.Write(fileTar(t)); e != nil {
+		t.Fatal(e)
+	}
+	if e := w.Close(); e != nil {
+		t.Fatal(e)
+	}
+	return b.Bytes()
+}
+
+// v7Tar turns the ustar archive of fileTar into an old style tar, which has no magic
+func v7Tar(t *testing.T) []byte {
+	b := fileTar(t)
+	copy(b[257:265], make([]byte, 8))
+	copy(b[148:156], "        ")
+	sum := 0
+	for _, c := range b[:512] {
+		sum += int(c)
+	}
+	copy(b[148:156], fmt.Sprintf("%06o\x00 ", sum))
+	return b
+}
+
+func TestExtractDetectsFormats(t *testing.T) {
+	bz2, e := base64.StdEncoding.DecodeString(bzip2Tar)
+	if e != nil {
+		t.Fatal(e)
+	}
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	w, _ := zw.Create("file")
+	_, _ = w.Write([]byte("x"))
+	if e := zw.Close(); e != nil {
+		t.Fatal(e)
+	}
+
+	tests := []struct {
+		name    string
+		archive []byte
+	}{
+		{"download", compressed(t, func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil })},
+		{"download", bz2},
+		{"download", compressed(t, func(w io.Writer) (io.WriteCloser, error) { return xz.NewWriter(w) })},
+		{"download", compressed(t, func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) })},
+		{"download", zipped.Bytes()},
+		{"download", fileTar(t)},
+		{"download.TAR", v7Tar(t)},
+		// the magic wins over a misleading suffix
+		{"download.zip", compressed(t, func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil })},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			archive := filepath.Join(t.TempDir(), test.name)
+			if e := os.WriteFile(archive, test.archive, 0644); e != nil {
+				t.Fatal(e)
+			}
+			root, _ := extractDirs(t)
+			if e := Extract(context.Background(), archive, root, ExtractOptions{}); e != nil {
+				t.Fatal(e)
+			}
+			if b, e := os.ReadFile(filepath.Join(root, "file")); e != nil || string(b) != "x" {
+				t.Errorf("extracted %q, %v", b, e)
+			}
+		})
+	}
+}
+
+func TestExtractRejectsUnknownAndTruncatedArchives(t *testing.T) {
+	gz := compressed(t, func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil })
+	zst := compressed(t, func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) })
+	tests := []struct {
+		name        string
+		archive     []byte
+		unsupported bool
+	}{
+		{"empty", nil, true},
+		{"text", []byte("<html>not found</html>"), true},
+		{"old style tar without a suffix", v7Tar(t), true},
+		{"truncated gzip", gz[:len(gz)/2], false},
+		{"truncated zstd", zst[:len(zst)/2], false},
+		{"truncated zip", []byte("PK\x03\x04\x14\x00"), false},
+		{"truncated tar", fileTar(t)[:300], false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			archive := filepath.Join(t.TempDir(), "download")
+			if e := os.WriteFile(archive, test.archive, 0644); e != nil {
+				t.Fatal(e)
+			}
+			root, _ := extractDirs(t)
+			e := Extract(context.Background(), archive, root, ExtractOptions{})
+			if !errors.Is(e, ErrExtract) || errors.Is(e, ErrUnsupportedArchive) != test.unsupported {
+				t.Errorf("got %v, want ErrExtract, unsupported %v", e, test.unsupported)
+			}
+			if names := dirNames(t, root); len(names) != 0 {
+				t.Errorf("left %v behind after failing", names)
+			}
+		})
+	}
+	if e := Extract(context.Background(), filepath.Join(t.TempDir(), "missing"), t.TempDir(), ExtractOptions{}); !errors.Is(e, ErrExtract) {
+		t.Errorf("got %v for a missing archive, want ErrExtract", e)
+	}
+}
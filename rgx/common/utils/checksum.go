@@ -0,0 +1,165 @@
+package utils
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/sha3"
+
+	"rgx/common/log"
+)
+
+var hashes = map[string]func() hash.Hash{
+	"sha1":        sha1.New,
+	"sha256":      sha256.New,
+	"sha384":      sha512.New384,
+	"sha512":      sha512.New,
+	"sha3-256":    sha3.New256,
+	"sha3-384":    sha3.New384,
+	"sha3-512":    sha3.New512,
+	"blake2b-256": func() hash.Hash { h, _ := blake2b.New256(nil); return h },
+	"blake2b-512": func() hash.Hash { h, _ := blake2b.New512(nil); return h },
+}
+
+// algorithmNames maps other spellings to the names in hashes. A recipe without a checksum
+// type has always meant sha256.
+var algorithmNames = map[string]string{
+	"":        "sha256",
+	"sha-1":   "sha1",
+	"sha-256": "sha256",
+	"sha-384": "sha384",
+	"sha-512": "sha512",
+	"sha3":    "sha3-256",
+	"blake2b": "blake2b-512",
+}
+
+// Algorithm returns the canonical name of a checksum algorithm, or an error wrapping
+// ErrUnsupportedChecksum
+func Algorithm(name string) (string, error) {
+	a := strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), "_", "-")
+	if canonical, ok := algorithmNames[a]; ok {
+		a = canonical
+	}
+	if _, ok := hashes[a]; !ok {
+		return "", fmt.Errorf("%w: %w: %q", ErrChecksumMismatch, ErrUnsupportedChecksum, name)
+	}
+	return a, nil
+}
+
+func (c Checksum) String() string {
+	return c.Algorithm + ":" + c.Hash
+}
+
+// ParseChecksum reads a checksum in one of the forms recipes use: a hex digest of the given
+// algorithm, "<algorithm>:<hex digest>", or a Subresource Integrity string like
+// "sha384-<base64 digest>". The result always has a canonical algorithm and a lowercase hex
+// digest.
+func ParseChecksum(s, algorithm string) (Checksum, error) {
+	s = strings.TrimSpace(s)
+	var digest []byte
+	if i := strings.LastIndex(s, "-"); i > 0 && !strings.Contains(s, ":") {
+		if a, e := Algorithm(s[:i]); e == nil {
+			b, e := base64.StdEncoding.DecodeString(s[i+1:])
+			if e != nil {
+				return Checksum{}, fmt.Errorf("%w: invalid checksum %q: %s", ErrChecksumMismatch, s, e.Error())
+			}
+			algorithm, digest = a, b
+		}
+	}
+	if digest == nil {
+		if a, hexDigest, ok := strings.Cut(s, ":"); ok {
+			algorithm, s = a, hexDigest
+		}
+		b, e := hex.DecodeString(s)
+		if e != nil {
+			return Checksum{}, fmt.Errorf("%w: invalid checksum %q", ErrChecksumMismatch, s)
+		}
+		digest = b
+	}
+
+	a, e := Algorithm(algorithm)
+	if e != nil {
+		return Checksum{}, e
+	}
+	if size := hashes[a]().Size(); len(digest) != size {
+		return Checksum{}, fmt.Errorf("%w: invalid checksum %q, a %s digest has %d bytes", ErrChecksumMismatch, s, a, size)
+	}
+	return Checksum{Algorithm: a, Hash: hex.EncodeToString(digest)}, nil
+}
+
+// Hash returns the checksum of filename, failing for algorithms it doesn't know
+func Hash(filename, algorithm string) (Checksum, error) {
+	a, e := Algorithm(algorithm)
+	if e != nil {
+		return Checksum{}, e
+	}
+	sums, e := hashFile(filename, []string{a})
+	if e != nil {
+		return Checksum{}, e
+	}
+	return sums[0], nil
+}
+
+// Verify checks filename against every checksum in expected, reading it only once. It
+// returns an error wrapping ErrChecksumMismatch for the first one that doesn't match.
+func Verify(filename string, expected []Checksum) error {
+	if len(expected) == 0 {
+		return nil
+	}
+	algorithms := make([]string, len(expected))
+	for i, c := range expected {
+		a, e := Algorithm(c.Algorithm)
+		if e != nil {
+			return e
+		}
+		algorithms[i] = a
+	}
+	sums, e := hashFile(filename, algorithms)
+	if e != nil {
+		return e
+	}
+	for i, c := range expected {
+		if sums[i].Hash != strings.ToLower(strings.TrimSpace(c.Hash)) {
+			log.Debug("checksum mismatch for: %s, expected %s but got %s", filename, c, sums[i])
+			return fmt.Errorf("%w: %s, expected %s but got %s", ErrChecksumMismatch, filename, c, sums[i].Hash)
+		}
+	}
+	return nil
+}
+
+func hashFile(filename string, algorithms []string) ([]Checksum, error) {
+	f, e := os.Open(filename)
+	if e != nil {
+		return nil, e
+	}
+	defer func(f *os.File) {
+		err := f.Close()
+		if err != nil {
+			log.Error("could not close downloaded file after hashing: %s", err.Error())
+		}
+	}(f)
+
+	hashers := make([]hash.Hash, len(algorithms))
+	writers := make([]io.Writer, len(algorithms))
+	for i, a := range algorithms {
+		hashers[i] = hashes[a]()
+		writers[i] = hashers[i]
+	}
+	if _, e := io.Copy(io.MultiWriter(writers...), f); e != nil {
+		return nil, e
+	}
+	sums := make([]Checksum, len(algorithms))
+	for i, h := range hashers {
+		sums[i] = Checksum{Algorithm: algorithms[i], Hash: hex.EncodeToString(h.Sum(nil))}
+	}
+	return sums, nil
+}
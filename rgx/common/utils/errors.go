@@ -0,0 +1,18 @@
+package utils
+
+import "errors"
+
+// Errors returned by this package are wrapped around one of these, so callers can tell
+// failures apart with errors.Is
+var (
+	ErrConfig           = errors.New("configuration error")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// ErrUnsupportedChecksum and ErrUnverified are wrapped together with ErrChecksumMismatch
+	ErrUnsupportedChecksum = errors.New("unsupported checksum algorithm")
+	ErrUnverified          = errors.New("no checksum to verify")
+	ErrExtract             = errors.New("extraction failed")
+	// ErrUnsupportedArchive is wrapped together with ErrExtract
+	ErrUnsupportedArchive = errors.New("unsupported archive format")
+	ErrScript             = errors.New("setup script failed")
+	ErrSignature          = errors.New("signature verification failed")
+)
@@ -1,13 +1,18 @@
 package utils
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os/exec"
 	"rgx/common/log"
 	"strings"
+	"time"
 )
 
-func RunScript(scriptCmd, scriptDir string, envMap map[string]string) {
+// RunScript stops the script when ctx is cancelled, or when it runs longer than timeout, the
+// script_timeout of the configuration
+func RunScript(ctx context.Context, scriptCmd, scriptDir string, envMap map[string]string, timeout time.Duration) error {
 	var command string
 	var args []string
 	if strings.HasSuffix(scriptCmd, ".cmd") {
@@ -17,7 +22,15 @@ func RunScript(scriptCmd, scriptDir string, envMap map[string]string) {
 		command = "sh"
 		args = []string{scriptCmd}
 	}
-	cmd := exec.Command(command, args...)
+	scriptCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		scriptCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(scriptCtx, command, args...)
+	// don't wait forever for children of the script that keep its output open
+	cmd.WaitDelay = 5 * time.Second
 	log.Trace("setting script directory to %s", scriptDir)
 	cmd.Dir = scriptDir
 	m := cmd.Environ()
@@ -26,8 +39,18 @@ func RunScript(scriptCmd, scriptDir string, envMap map[string]string) {
 	}
 	cmd.Env = m
 	output, err := cmd.CombinedOutput()
-	if err != nil {
-		log.Fatal("could not run command '%s': %s", scriptCmd, err.Error())
+	for _, line := range strings.Split(strings.TrimRight(string(output), "\r\n"), "\n") {
+		if line = strings.TrimRight(line, "\r"); line != "" {
+			log.Info("%s: %s", scriptCmd, line)
+		}
+	}
+	switch {
+	case ctx.Err() != nil:
+		return fmt.Errorf("%w: '%s' was interrupted: %w", ErrScript, scriptCmd, ctx.Err())
+	case errors.Is(scriptCtx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("%w: '%s' did not finish within %s, see script_timeout", ErrScript, scriptCmd, timeout)
+	case err != nil:
+		return fmt.Errorf("%w: could not run command '%s': %s", ErrScript, scriptCmd, err.Error())
 	}
-	fmt.Println(string(output))
+	return nil
 }
@@ -1,12 +1,9 @@
 package utils
 
 import (
-	"crypto/sha1"
-	"crypto/sha256"
-	"encoding/hex"
+	"context"
 	"errors"
 	"fmt"
-	"hash"
 	"io"
 	"os"
 	"path/filepath"
@@ -49,35 +46,6 @@ func removeContents(dir string) error {
 	return nil
 }
 
-func Hash(filename, algorithm string) (Checksum, error) {
-	f, e := os.Open(filename)
-	if e != nil {
-		return Checksum{"", ""}, e
-	}
-	defer func(f *os.File) {
-		err := f.Close()
-		if err != nil {
-			log.Error("could not close downloaded file after hashing: %s", err.Error())
-		}
-	}(f)
-
-	var hasher hash.Hash
-	switch algorithm {
-	case "sha1":
-		hasher = sha1.New()
-	default:
-		hasher = sha256.New()
-	}
-	if _, e := io.Copy(hasher, f); e != nil {
-		return Checksum{"", ""}, e
-	}
-	return Checksum{
-			Algorithm: algorithm,
-			Hash:      hex.EncodeToString(hasher.Sum(nil)),
-		},
-		nil
-}
-
 func Copy(src, dest string) (int64, error) {
 	sourceFileStat, err := os.Stat(src)
 	if err != nil {
@@ -113,7 +81,7 @@ func Copy(src, dest string) (int64, error) {
 	return nBytes, err
 }
 
-func ExtractToTemp(source, artifactType string) (string, error) {
+func ExtractToTemp(ctx context.Context, source, artifactType string, opts ExtractOptions) (string, error) {
 	if !Exists(source) {
 		return "", errors.New("file not found: " + source)
 	}
@@ -121,7 +89,9 @@ func ExtractToTemp(source, artifactType string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	Extract(source, tempdir)
+	if err = Extract(ctx, source, tempdir, opts); err != nil {
+		return tempdir, err
+	}
 	return tempdir, nil
 }
 
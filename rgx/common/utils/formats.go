@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+type archiveFormat string
+
+const (
+	formatZip    archiveFormat = "zip"
+	formatTar    archiveFormat = "tar"
+	formatTarGz  archiveFormat = "tar.gz"
+	formatTarBz2 archiveFormat = "tar.bz2"
+	formatTarXz  archiveFormat = "tar.xz"
+	formatTarZst archiveFormat = "tar.zst"
+)
+
+var magicBytes = []struct {
+	magic  []byte
+	format archiveFormat
+}{
+	{[]byte("PK\x03\x04"), formatZip},
+	{[]byte("PK\x05\x06"), formatZip}, // an empty zip
+	{[]byte{0x1f, 0x8b}, formatTarGz},
+	{[]byte("BZh"), formatTarBz2},
+	{[]byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, formatTarXz},
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, formatTarZst},
+}
+
+// suffixes are only used for files without a recognisable header, i.e. old style tar files
+var suffixes = []struct {
+	suffix string
+	format archiveFormat
+}{
+	{".tar", formatTar},
+}
+
+// detectFormat looks at the first bytes of the archive, since download names don't always
+// have the usual suffix
+func detectFormat(archiveName string) (archiveFormat, error) {
+	f, e := os.Open(archiveName)
+	if e != nil {
+		return "", fmt.Errorf("%w: %s", ErrExtract, e.Error())
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	header := make([]byte, 512)
+	n, e := io.ReadFull(f, header)
+	if e != nil && e != io.ErrUnexpectedEOF && e != io.EOF {
+		return "", fmt.Errorf("%w: %s: %s", ErrExtract, archiveName, e.Error())
+	}
+	header = header[:n]
+
+	for _, m := range magicBytes {
+		if bytes.HasPrefix(header, m.magic) {
+			return m.format, nil
+		}
+	}
+	// POSIX tar files have "ustar" at offset 257
+	if len(header) >= 262 && string(header[257:262]) == "ustar" {
+		return formatTar, nil
+	}
+	for _, s := range suffixes {
+		if strings.HasSuffix(strings.ToLower(archiveName), s.suffix) {
+			return s.format, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %w: %s", ErrExtract, ErrUnsupportedArchive, archiveName)
+}
+
+// decompress returns the tar stream inside a compressed one
+func decompress(format archiveFormat, r io.Reader) (io.Reader, error) {
+	switch format {
+	case formatTar:
+		return r, nil
+	case formatTarGz:
+		return gzip.NewReader(r)
+	case formatTarBz2:
+		return bzip2.NewReader(r), nil
+	case formatTarXz:
+		return xz.NewReader(r)
+	case formatTarZst:
+		d, e := zstd.NewReader(r)
+		if e != nil {
+			return nil, e
+		}
+		return d.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedArchive, format)
+}
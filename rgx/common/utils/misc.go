@@ -2,15 +2,14 @@ package utils
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 	"os"
 	"os/user"
 	"path/filepath"
 	"rgx/common/log"
 	"runtime"
-	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pelletier/go-toml/v2"
 )
@@ -18,47 +17,102 @@ import (
 func ShowConfig() {
 	fmt.Println("Emvironment Variables:")
 	printEnvVariable("RGX_CONFIG_DIR")
-	cf := configFileName()
+	cf, _ := configFileName()
 	fmt.Printf("\nConfig file: %v\n", cf)
 }
 
-func Configure() {
-	var e error
-
+func Configure(config *RgxConfig) error {
 	// set up directories we need
-	e = os.MkdirAll(Config.DownloadDir, 0775)
-	ErrCheck(e)
-	e = os.MkdirAll(Config.PackagesDir, 0775)
-	ErrCheck(e)
-	e = os.MkdirAll(TempDir(), 0775)
-	ErrCheck(e)
+	for _, dir := range []string{config.DownloadDir, config.PackagesDir, TempDir(), config.ShimsDir} {
+		if e := os.MkdirAll(dir, 0775); e != nil {
+			return fmt.Errorf("%w: could not create %s: %s", ErrConfig, dir, e.Error())
+		}
+	}
+	return nil
 }
 
 var ProgramSettings Dict
 
-func ReadConfig() RgxConfig {
-	configFile := configFileName()
+func ReadConfig() (RgxConfig, error) {
+	var config RgxConfig
+	configFile, e := configFileName()
+	if e != nil {
+		return config, fmt.Errorf("%w: %s", ErrConfig, e.Error())
+	}
 	configBytes, e := os.ReadFile(configFile)
 	if e != nil {
-		fmt.Printf("error: couldn't open config file: '%v'\n", configFile)
-		fmt.Println("    Ensure the file exists, and unset the RGX_CONFIG_DIR")
-		fmt.Println("    environment variable if necessary.")
-		os.Exit(1)
+		return config, fmt.Errorf("%w: couldn't open config file: '%v'. Ensure the file exists, "+
+			"and unset the RGX_CONFIG_DIR environment variable if necessary", ErrConfig, configFile)
 	}
 
-	var config RgxConfig
 	e = toml.Unmarshal(configBytes, &ProgramSettings)
-	ErrCheck(e)
+	if e != nil {
+		return config, fmt.Errorf("%w: could not parse %s: %s", ErrConfig, configFile, e.Error())
+	}
 
 	plat := PlatformOS()
+	if _, ok := ProgramSettings[plat].(map[string]any); !ok {
+		return config, fmt.Errorf("%w: no [%s] section found in %s", ErrConfig, plat, configFile)
+	}
+	config.ConfigFile = configFile
 	config.ServerUrl = ProgramSettings.GetString("server_url", "")
 	config.ShowProgress = ProgramSettings.GetBool("show_progress", false)
 
 	config.PackagesDir = replaceTilde(ProgramSettings.GetDict(plat).GetString("packages_dir", "~/rgx-packages"))
 	config.DownloadDir = replaceTilde(ProgramSettings.GetDict(plat).GetString("download_dir", "/tmp"))
 	config.RcFileDir = replaceTilde(ProgramSettings.GetDict(plat).GetString("rcfile_dir", "~"))
+	config.ShimsDir = replaceTilde(ProgramSettings.GetDict(plat).GetString("shims_dir", filepath.Join(config.PackagesDir, "shims")))
 
-	return config
+	config.HttpTimeout, e = ProgramSettings.GetDuration("http_timeout", 30*time.Second)
+	if e != nil {
+		return config, fmt.Errorf("%w: %s: %s", ErrConfig, configFile, e.Error())
+	}
+	config.ScriptTimeout, e = ProgramSettings.GetDuration("script_timeout", 10*time.Minute)
+	if e != nil {
+		return config, fmt.Errorf("%w: %s: %s", ErrConfig, configFile, e.Error())
+	}
+	config.HttpRetries = ProgramSettings.GetInt("http_retries", 3)
+	config.MaxParallelDownloads = ProgramSettings.GetInt("max_parallel_downloads", 4)
+	config.HttpRetryDelay, e = ProgramSettings.GetDuration("http_retry_delay", time.Second)
+	if e != nil {
+		return config, fmt.Errorf("%w: %s: %s", ErrConfig, configFile, e.Error())
+	}
+	if e = readSignatureConfig(&config); e != nil {
+		return config, fmt.Errorf("%w: %s: %s", ErrConfig, configFile, e.Error())
+	}
+
+	return config, nil
+}
+
+func readSignatureConfig(config *RgxConfig) error {
+	keys, e := ProgramSettings.GetStrings("trusted_keys")
+	if e != nil {
+		return e
+	}
+	for _, k := range keys {
+		key, e := ParsePublicKey(k)
+		if e != nil {
+			return e
+		}
+		config.TrustedKeys = append(config.TrustedKeys, key)
+	}
+
+	// without pinned keys, the default refuses every recipe, see candidates.verifyRecipe
+	switch config.RecipeSignatures = ProgramSettings.GetString("recipe_signatures", SignaturesRequire); config.RecipeSignatures {
+	case SignaturesRequire, SignaturesWarn, SignaturesOff:
+	default:
+		return fmt.Errorf("recipe_signatures must be \"require\", \"warn\" or \"off\", not %q", config.RecipeSignatures)
+	}
+
+	config.VendorKeys = make(map[string]string)
+	for pkg, v := range ProgramSettings.GetDict("vendor_keys") {
+		file, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("vendor_keys.%s must be the name of a key file", pkg)
+		}
+		config.VendorKeys[pkg] = replaceTilde(file)
+	}
+	return nil
 }
 
 func replaceTilde(s string) string {
@@ -86,19 +140,22 @@ func printEnvVariable(v string) {
 	fmt.Printf("  %v = %v\n", v, r)
 }
 
-func configFileName() string {
+func configFileName() (string, error) {
 	var configFile = "rgx.toml"
 	configDir := os.Getenv("RGX_CONFIG_DIR")
 	if configDir != "" {
-		fmt.Printf("reading config from: %s\n", configDir)
+		// stderr, so that the output of binaries run through shims stays clean
+		fmt.Fprintf(os.Stderr, "reading config from: %s\n", configDir)
 		configFile = filepath.Join(configDir, configFile)
 	} else { // the config file is in the same directory as the exe
 		exePath, e := os.Executable()
-		ErrCheck(e)
+		if e != nil {
+			return "", e
+		}
 		exeDir := filepath.Dir(exePath)
 		configFile = filepath.Join(exeDir, configFile)
 	}
-	return configFile
+	return configFile, nil
 }
 
 //goland:noinspection GoBoolExpressions
@@ -134,61 +191,15 @@ func Exists(fname string) bool {
 	}
 }
 
-func ErrCheck(e error) {
-	if e != nil {
-		fmt.Println(e.Error())
-		os.Exit(1)
-	}
-}
-
 func TempDir() string {
 	return filepath.Join(os.TempDir(), "rgx-temp")
 }
 
-func Assert(condition bool, failMsg string) {
-	if !condition {
-		panic(failMsg)
-	}
-}
-
 func PrettyPrint(i interface{}) string {
 	s, _ := json.MarshalIndent(i, "", "  ")
 	return string(s)
 }
 
-func CompareVersion(ver1, ver2 string) (int, error) {
-	if ver1 == ver2 {
-		return 0, nil
-	}
-
-	c1 := strings.Count(ver1, ".")
-	c2 := strings.Count(ver2, ".")
-	if c1 != c2 {
-		return 0, errors.New("version strings are not comparable")
-	}
-
-	v1Parts := strings.Split(ver1, ".")
-	v2Parts := strings.Split(ver2, ".")
-
-	for i := 0; i < c1; i++ {
-		v1, err := strconv.Atoi(v1Parts[i])
-		if err != nil {
-			return 0, err
-		}
-		v2, err := strconv.Atoi(v2Parts[i])
-		if err != nil {
-			return 0, err
-		}
-		if v1 < v2 {
-			return -1, nil
-		} else if v1 > v2 {
-			return 1, nil
-		}
-	}
-
-	return 0, errors.New("unexpected error")
-}
-
 func GetRuntimeConfig() RuntimeConfig {
 	hostname, err := os.Hostname()
 	if err != nil {
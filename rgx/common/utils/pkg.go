@@ -7,5 +7,4 @@ const ApplicationShortDescription = "A CLI based package manager"
 const ApplicationDescription = "rgx allows you to manage software packages through CLI"
 const UserAgent = ApplicationName + "/" + Version
 
-var Config RgxConfig
 var CurrentRuntimeConfig = GetRuntimeConfig()
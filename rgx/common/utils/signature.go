@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// SignatureHeader carries the signatures of a recipe, as comma separated
+// "<key id>:<base64 signature>". Several let a server sign with a new key while clients
+// still pin the old one.
+const SignatureHeader = "X-Rgx-Signature"
+
+// Values of recipe_signatures in rgx.toml
+const (
+	SignaturesRequire = "require"
+	SignaturesWarn    = "warn"
+	SignaturesOff     = "off"
+)
+
+// PublicKey is an ed25519 key; ID is the first 8 bytes of the sha256 of the key, in hex
+type PublicKey struct {
+	ID  string
+	Key ed25519.PublicKey
+}
+
+// ParsePublicKey reads a key the way rgx.toml pins it, "ed25519:<base64>"
+func ParsePublicKey(s string) (PublicKey, error) {
+	s = strings.TrimSpace(s)
+	b64, ok := strings.CutPrefix(s, "ed25519:")
+	if !ok {
+		return PublicKey{}, fmt.Errorf("%w: %q is not an ed25519 public key, expected ed25519:<base64>", ErrSignature, s)
+	}
+	b, e := base64.StdEncoding.DecodeString(b64)
+	if e != nil || len(b) != ed25519.PublicKeySize {
+		return PublicKey{}, fmt.Errorf("%w: %q is not an ed25519 public key", ErrSignature, s)
+	}
+	return PublicKey{ID: keyID(b), Key: b}, nil
+}
+
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// RecipeMessage is what a server signs for a recipe: the request it answers, so that a signed
+// recipe can't be served for another package or platform, and the body as sent
+func RecipeMessage(pkg, release, opsys, arch string, body []byte) []byte {
+	header := fmt.Sprintf("rgx-recipe %s/%s/%s/%s\n", pkg, release, opsys, arch)
+	return append([]byte(header), body...)
+}
+
+// VerifySignature checks that one of the signatures in header is a valid signature of message
+// by one of keys, and returns the id of that key. It wraps ErrSignature otherwise.
+func VerifySignature(keys []PublicKey, message []byte, header string) (string, error) {
+	if strings.TrimSpace(header) == "" {
+		return "", fmt.Errorf("%w: not signed", ErrSignature)
+	}
+	var ids []string
+	for _, sig := range strings.Split(header, ",") {
+		id, b64, _ := strings.Cut(strings.TrimSpace(sig), ":")
+		ids = append(ids, id)
+		b, e := base64.StdEncoding.DecodeString(b64)
+		if e != nil {
+			continue
+		}
+		for _, k := range keys {
+			if k.ID == id && ed25519.Verify(k.Key, message, b) {
+				return id, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%w: no valid signature by a trusted key, signed by %s", ErrSignature, strings.Join(ids, ", "))
+}
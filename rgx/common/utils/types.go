@@ -2,10 +2,12 @@ package utils
 
 import (
 	"fmt"
-	"os"
+	"time"
 )
 
 type RgxConfig struct {
+	// ConfigFile is the rgx.toml the config was read from
+	ConfigFile           string
 	ServerUrl            string
 	ArtifactRegistryBase string
 	ArtifactRegistryAuth string
@@ -13,6 +15,26 @@ type RgxConfig struct {
 	PackagesDir          string
 	DownloadDir          string
 	RcFileDir            string
+	ShimsDir             string
+	// HttpTimeout limits connecting to a server and waiting for it to respond, 0 for no limit
+	HttpTimeout time.Duration
+	// ScriptTimeout limits how long a setup script may run, 0 for no limit
+	ScriptTimeout time.Duration
+	// HttpRetries is how often a request that failed for a transient reason is retried
+	HttpRetries int
+	// HttpRetryDelay is the delay before the first retry, which doubles with each retry
+	HttpRetryDelay time.Duration
+	// MaxParallelDownloads limits how many artifacts of a recipe are downloaded at once
+	MaxParallelDownloads int
+	// AllowUnverified lets artifacts without a checksum be installed, it is never read from rgx.toml
+	AllowUnverified bool
+	// TrustedKeys are the keys recipes must be signed with
+	TrustedKeys []PublicKey
+	// RecipeSignatures is what happens to a recipe without a valid signature: SignaturesRequire
+	// refuses it, SignaturesWarn logs a warning and SignaturesOff does not check
+	RecipeSignatures string
+	// VendorKeys maps packages to the key file their artifacts' own signatures are checked with
+	VendorKeys map[string]string
 }
 
 type NexusArtifact struct {
@@ -38,13 +60,12 @@ type RuntimeConfig struct {
 
 type Dict map[string]any
 
+// GetDict returns an empty Dict if there is no table named k
 func (d Dict) GetDict(k string) Dict {
-	if _, ok := d[k]; !ok {
-		fmt.Printf("No dictionary entry found for: %s\n", k)
-		fmt.Println("Please check the configuration file")
-		os.Exit(1)
+	if t, ok := d[k].(map[string]any); ok {
+		return t
 	}
-	return d[k].(map[string]any)
+	return Dict{}
 }
 
 func (d Dict) GetString(k, fallback string) string {
@@ -63,6 +84,39 @@ func (d Dict) GetInt(k string, fallback int) int {
 	}
 }
 
+// GetDuration accepts a duration such as "90s" or "5m", or a number of seconds
+func (d Dict) GetDuration(k string, fallback time.Duration) (time.Duration, error) {
+	switch v := d[k].(type) {
+	case nil:
+		return fallback, nil
+	case int64:
+		return time.Duration(v) * time.Second, nil
+	case string:
+		return time.ParseDuration(v)
+	}
+	return fallback, fmt.Errorf("%s must be a duration such as \"30s\" or a number of seconds", k)
+}
+
+// GetStrings returns nil if there is no array named k
+func (d Dict) GetStrings(k string) ([]string, error) {
+	if d[k] == nil {
+		return nil, nil
+	}
+	a, ok := d[k].([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s must be an array of strings", k)
+	}
+	var r []string
+	for _, v := range a {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s must be an array of strings", k)
+		}
+		r = append(r, s)
+	}
+	return r, nil
+}
+
 func (d Dict) GetBool(k string, fallback bool) bool {
 	if d[k] == nil {
 		return fallback
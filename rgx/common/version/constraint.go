@@ -0,0 +1,233 @@
+package version
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Constraint is a set of version ranges, e.g. ~1.21, >=1.20 <1.23, 1.22.x or ^1.2 || ~2.1
+type Constraint struct {
+	ranges   []versionRange
+	allowPre bool
+	original string
+}
+
+type bound struct {
+	v         Version
+	inclusive bool
+	set       bool
+}
+
+// versionRange is the intersection of the comparators separated by spaces or commas
+type versionRange struct {
+	lower bound
+	upper bound
+}
+
+// IsConstraint is true if s is a range or wildcard rather than a plain version
+func IsConstraint(s string) bool {
+	s = strings.TrimSpace(s)
+	if strings.ContainsAny(s, "~^<>=*| ,") {
+		return true
+	}
+	for _, p := range strings.Split(s, ".") {
+		if p == "x" || p == "X" {
+			return true
+		}
+	}
+	return false
+}
+
+func ParseConstraint(s string) (Constraint, error) {
+	c := Constraint{original: s}
+	for _, alternative := range strings.Split(s, "||") {
+		fields := strings.Fields(strings.ReplaceAll(alternative, ",", " "))
+		if len(fields) == 0 {
+			return c, fmt.Errorf("invalid version constraint: '%s'", s)
+		}
+		r := versionRange{}
+		for i := 0; i < len(fields); i++ {
+			f := fields[i]
+			// allow a space between the operator and the version, e.g. ">= 1.20"
+			if strings.Trim(f, "~^<>=") == "" && i+1 < len(fields) {
+				f += fields[i+1]
+				i++
+			}
+			cr, pre, e := parseComparator(f)
+			if e != nil {
+				return c, fmt.Errorf("invalid version constraint: '%s': %s", s, e.Error())
+			}
+			r = r.intersect(cr)
+			c.allowPre = c.allowPre || pre
+		}
+		c.ranges = append(c.ranges, r)
+	}
+	return c, nil
+}
+
+func MustParseConstraint(s string) Constraint {
+	c, e := ParseConstraint(s)
+	if e != nil {
+		panic(e)
+	}
+	return c
+}
+
+func (c Constraint) String() string {
+	return c.original
+}
+
+// Check is true if v satisfies the constraint. Pre-releases only satisfy constraints that
+// mention a pre-release themselves.
+func (c Constraint) Check(v Version) bool {
+	if v.PreRelease != "" && !c.allowPre {
+		return false
+	}
+	for _, r := range c.ranges {
+		if r.contains(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsSeries is true if some version starting with prefix could satisfy the constraint,
+// e.g. ~1.21.3 allows the series 1.21 but not 1.20 or 1.22
+func (c Constraint) AllowsSeries(prefix Version) bool {
+	series := versionRange{
+		lower: bound{v: prefix.release(), inclusive: true, set: true},
+		upper: bound{v: prefix.next(len(prefix.Parts) - 1), inclusive: false, set: true},
+	}
+	for _, r := range c.ranges {
+		if !r.intersect(series).empty() {
+			return true
+		}
+	}
+	return false
+}
+
+// parseComparator turns a single comparator into a range; pre is true if it names a pre-release
+func parseComparator(s string) (r versionRange, pre bool, e error) {
+	op := s[:len(s)-len(strings.TrimLeft(s, "~^<>="))]
+	text := s[len(op):]
+
+	// wildcards: 1.22.x, 1.22.*, 1.x; everything before the wildcard is a prefix
+	wildcard := false
+	var kept []string
+	for _, p := range strings.Split(text, ".") {
+		if p == "x" || p == "X" || p == "*" {
+			wildcard = true
+			break
+		}
+		kept = append(kept, p)
+	}
+	if len(kept) == 0 {
+		if op != "" && op != "=" {
+			return r, false, fmt.Errorf("%s needs a version", op)
+		}
+		return versionRange{}, false, nil // * matches everything
+	}
+	v, e := Parse(strings.Join(kept, "."))
+	if e != nil {
+		return r, false, e
+	}
+	if wildcard && op != "" && op != "=" {
+		return r, false, fmt.Errorf("wildcards cannot be combined with %s", op)
+	}
+	pre = v.PreRelease != ""
+
+	switch op {
+	case "", "=":
+		if len(v.Parts) >= 3 || pre {
+			// a full version is an exact match
+			return versionRange{lower: bound{v, true, true}, upper: bound{v, true, true}}, pre, nil
+		}
+		// a partial version such as 1.22 is the whole 1.22 series
+		return versionRange{
+			lower: bound{v, true, true},
+			upper: bound{v.next(len(v.Parts) - 1), false, true},
+		}, pre, nil
+	case "~":
+		// ~1.21.3 and ~1.21 are >=1.21.x <1.22, and ~1 is >=1 <2
+		i := 1
+		if len(v.Parts) == 1 {
+			i = 0
+		}
+		return versionRange{lower: bound{v, true, true}, upper: bound{v.next(i), false, true}}, pre, nil
+	case "^":
+		// ^1.2.3 is >=1.2.3 <2, ^0.2.3 is >=0.2.3 <0.3
+		i := 0
+		for i < len(v.Parts)-1 && v.Parts[i] == 0 {
+			i++
+		}
+		return versionRange{lower: bound{v, true, true}, upper: bound{v.next(i), false, true}}, pre, nil
+	case ">":
+		return versionRange{lower: bound{v, false, true}}, pre, nil
+	case ">=":
+		return versionRange{lower: bound{v, true, true}}, pre, nil
+	case "<":
+		return versionRange{upper: bound{v, false, true}}, pre, nil
+	case "<=":
+		return versionRange{upper: bound{v, true, true}}, pre, nil
+	}
+	return r, false, fmt.Errorf("unknown operator %s", op)
+}
+
+// next increments component i and drops the ones after it, e.g. 1.21.3 next(1) is 1.22
+func (v Version) next(i int) Version {
+	parts := make([]int, i+1)
+	copy(parts, v.Parts)
+	parts[i]++
+	return Version{Parts: parts}
+}
+
+func (v Version) release() Version {
+	return Version{Parts: v.Parts}
+}
+
+func (r versionRange) contains(v Version) bool {
+	if r.lower.set {
+		c := v.Compare(r.lower.v)
+		if c < 0 || (c == 0 && !r.lower.inclusive) {
+			return false
+		}
+	}
+	if r.upper.set {
+		c := v.Compare(r.upper.v)
+		if c > 0 || (c == 0 && !r.upper.inclusive) {
+			return false
+		}
+		// pre-releases of an excluded upper bound are excluded too, so <1.23 doesn't match 1.23rc1
+		if !r.upper.inclusive && v.PreRelease != "" && r.upper.v.PreRelease == "" && v.release().Equal(r.upper.v) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r versionRange) intersect(o versionRange) versionRange {
+	result := r
+	if o.lower.set {
+		if !result.lower.set {
+			result.lower = o.lower
+		} else if c := o.lower.v.Compare(result.lower.v); c > 0 || (c == 0 && !o.lower.inclusive) {
+			result.lower = o.lower
+		}
+	}
+	if o.upper.set {
+		if !result.upper.set {
+			result.upper = o.upper
+		} else if c := o.upper.v.Compare(result.upper.v); c < 0 || (c == 0 && !o.upper.inclusive) {
+			result.upper = o.upper
+		}
+	}
+	return result
+}
+
+func (r versionRange) empty() bool {
+	if !r.lower.set || !r.upper.set {
+		return false
+	}
+	c := r.lower.v.Compare(r.upper.v)
+	return c > 0 || (c == 0 && !(r.lower.inclusive && r.upper.inclusive))
+}
@@ -0,0 +1,170 @@
+package version
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Version is a semver-ish version: any number of numeric components, optionally followed by a
+// pre-release tag, e.g. 1.22.3, 502.0.0, 1.21 or 1.22rc1
+type Version struct {
+	Parts      []int
+	PreRelease string
+	original   string
+}
+
+// Parse accepts an optional v or go prefix, and ignores build metadata after a +
+func Parse(s string) (Version, error) {
+	v := Version{original: s}
+	t := strings.TrimSpace(s)
+	t = strings.TrimPrefix(t, "go")
+	t = strings.TrimPrefix(t, "v")
+	if i := strings.Index(t, "+"); i >= 0 {
+		t = t[:i]
+	}
+
+	end := strings.IndexFunc(t, func(r rune) bool {
+		return r != '.' && !unicode.IsDigit(r)
+	})
+	numeric, pre := t, ""
+	if end >= 0 {
+		numeric, pre = t[:end], t[end:]
+	}
+	numeric = strings.TrimSuffix(numeric, ".")
+	if numeric == "" {
+		return v, fmt.Errorf("invalid version: '%s'", s)
+	}
+	for _, p := range strings.Split(numeric, ".") {
+		n, e := strconv.Atoi(p)
+		if e != nil {
+			return v, fmt.Errorf("invalid version: '%s'", s)
+		}
+		v.Parts = append(v.Parts, n)
+	}
+	v.PreRelease = strings.TrimLeft(pre, "-.")
+	return v, nil
+}
+
+func MustParse(s string) Version {
+	v, e := Parse(s)
+	if e != nil {
+		panic(e)
+	}
+	return v
+}
+
+func (v Version) String() string {
+	if v.original != "" {
+		return v.original
+	}
+	var parts []string
+	for _, p := range v.Parts {
+		parts = append(parts, strconv.Itoa(p))
+	}
+	s := strings.Join(parts, ".")
+	if v.PreRelease != "" {
+		s += "-" + v.PreRelease
+	}
+	return s
+}
+
+func (v Version) part(i int) int {
+	if i < len(v.Parts) {
+		return v.Parts[i]
+	}
+	return 0
+}
+
+// Compare returns -1, 0 or 1. Missing components count as 0, so 1.21 == 1.21.0, and a
+// pre-release sorts before its release, so 1.22rc1 < 1.22
+func (v Version) Compare(o Version) int {
+	n := max(len(v.Parts), len(o.Parts))
+	for i := 0; i < n; i++ {
+		if a, b := v.part(i), o.part(i); a != b {
+			return cmp.Compare(a, b)
+		}
+	}
+	switch {
+	case v.PreRelease == o.PreRelease:
+		return 0
+	case v.PreRelease == "":
+		return 1
+	case o.PreRelease == "":
+		return -1
+	}
+	return comparePreRelease(v.PreRelease, o.PreRelease)
+}
+
+func (v Version) Less(o Version) bool {
+	return v.Compare(o) < 0
+}
+
+func (v Version) Equal(o Version) bool {
+	return v.Compare(o) == 0
+}
+
+// Compare parses and compares two version strings
+func Compare(a, b string) (int, error) {
+	va, e := Parse(a)
+	if e != nil {
+		return 0, e
+	}
+	vb, e := Parse(b)
+	if e != nil {
+		return 0, e
+	}
+	return va.Compare(vb), nil
+}
+
+// comparePreRelease compares tags like rc1, beta.2 or alpha10 piece by piece, numbers numerically
+func comparePreRelease(a, b string) int {
+	pa, pb := splitPreRelease(a), splitPreRelease(b)
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		na, errA := strconv.Atoi(pa[i])
+		nb, errB := strconv.Atoi(pb[i])
+		switch {
+		case errA == nil && errB == nil:
+			if na != nb {
+				return cmp.Compare(na, nb)
+			}
+		case errA == nil:
+			return -1 // numeric identifiers sort before alphanumeric ones, as in semver
+		case errB == nil:
+			return 1
+		default:
+			if c := strings.Compare(pa[i], pb[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return cmp.Compare(len(pa), len(pb))
+}
+
+func splitPreRelease(s string) []string {
+	var pieces []string
+	var current strings.Builder
+	lastDigit := false
+	for i, r := range s {
+		if r == '.' || r == '-' {
+			if current.Len() > 0 {
+				pieces = append(pieces, current.String())
+				current.Reset()
+			}
+			continue
+		}
+		isDigit := unicode.IsDigit(r)
+		if i > 0 && current.Len() > 0 && isDigit != lastDigit {
+			pieces = append(pieces, current.String())
+			current.Reset()
+		}
+		current.WriteRune(r)
+		lastDigit = isDigit
+	}
+	if current.Len() > 0 {
+		pieces = append(pieces, current.String())
+	}
+	return pieces
+}
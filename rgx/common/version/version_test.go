@@ -0,0 +1,179 @@
+package version
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseRejectsInvalidVersions(t *testing.T) {
+	for _, s := range []string{"", " ", "abc", "v", "go", "1..2", ".1", "x.1"} {
+		if _, e := Parse(s); e == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in    string
+		parts []int
+		pre   string
+	}{
+		{"1.22.3", []int{1, 22, 3}, ""},
+		{"go1.22.3", []int{1, 22, 3}, ""},
+		{"v2.0", []int{2, 0}, ""},
+		{"1.22rc1", []int{1, 22}, "rc1"},
+		{"1.2.3-beta.2", []int{1, 2, 3}, "beta.2"},
+		{"1.2.3+build.5", []int{1, 2, 3}, ""},
+		{"502.0.0", []int{502, 0, 0}, ""},
+	}
+	for _, test := range tests {
+		v, e := Parse(test.in)
+		if e != nil {
+			t.Errorf("Parse(%q): %s", test.in, e)
+			continue
+		}
+		if !slices.Equal(v.Parts, test.parts) || v.PreRelease != test.pre {
+			t.Errorf("Parse(%q) = %v %q, want %v %q", test.in, v.Parts, v.PreRelease, test.parts, test.pre)
+		}
+		if v.String() != test.in {
+			t.Errorf("Parse(%q).String() = %q", test.in, v.String())
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.21", "1.21.0", 0},
+		{"go1.22.3", "1.22.3", 0},
+		{"1.22.3", "1.22.10", -1},
+		{"1.9", "1.10", -1},
+		{"2", "1.99.99", 1},
+		{"1.22rc1", "1.22", -1},
+		{"1.22rc1", "1.22.0", -1},
+		{"1.22rc1", "1.21.9", 1},
+		{"1.22rc1", "1.22rc2", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.10", -1},
+		{"1.0.0-1", "1.0.0-alpha", -1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+	}
+	for _, test := range tests {
+		got, e := Compare(test.a, test.b)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if got != test.want {
+			t.Errorf("Compare(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+		if back, _ := Compare(test.b, test.a); back != -test.want {
+			t.Errorf("Compare(%q, %q) = %d, want %d", test.b, test.a, back, -test.want)
+		}
+	}
+	if _, e := Compare("1.2", "abc"); e == nil {
+		t.Error("Compare with an invalid version succeeded")
+	}
+}
+
+func TestConstraintCheck(t *testing.T) {
+	tests := []struct {
+		constraint string
+		matches    []string
+		rejects    []string
+	}{
+		{"1.22.3", []string{"1.22.3", "go1.22.3"}, []string{"1.22.4", "1.22"}},
+		{"1.22", []string{"1.22", "1.22.0", "1.22.9"}, []string{"1.21.9", "1.23", "1.22rc1"}},
+		{"~1.21.3", []string{"1.21.3", "1.21.9"}, []string{"1.21.2", "1.22.0"}},
+		{"~1.21", []string{"1.21.0", "1.21.5"}, []string{"1.20.9", "1.22"}},
+		{"~1", []string{"1.0", "1.99"}, []string{"0.9", "2.0"}},
+		{"^1.2.3", []string{"1.2.3", "1.9.0"}, []string{"1.2.2", "2.0.0"}},
+		{"^0.2.3", []string{"0.2.3", "0.2.9"}, []string{"0.2.2", "0.3.0"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.4", "0.1.0"}},
+		{">1.20", []string{"1.20.1", "2.0"}, []string{"1.20", "1.19"}},
+		{"<=1.20", []string{"1.20", "1.20.0", "1.2"}, []string{"1.20.1"}},
+		{">=1.20 <1.23", []string{"1.20", "1.22.9"}, []string{"1.19.9", "1.23", "1.23rc1"}},
+		{">= 1.20, < 1.23", []string{"1.20", "1.22.9"}, []string{"1.19.9", "1.23"}},
+		{"1.22.x", []string{"1.22.0", "1.22.7"}, []string{"1.21.9", "1.23.0"}},
+		{"1.*", []string{"1.0", "1.99.1"}, []string{"0.9", "2.0"}},
+		{"*", []string{"0.1", "502.0.0"}, []string{"1.22rc1"}},
+		{"^1.2 || ~2.1", []string{"1.2.0", "1.9", "2.1.4"}, []string{"1.1", "2.0", "2.2"}},
+		{"1.22rc1", []string{"1.22rc1"}, []string{"1.22rc2", "1.22"}},
+		{">=1.22rc1", []string{"1.22rc1", "1.22rc2", "1.22", "1.23rc1"}, []string{"1.22beta1", "1.21"}},
+	}
+	for _, test := range tests {
+		c, e := ParseConstraint(test.constraint)
+		if e != nil {
+			t.Errorf("ParseConstraint(%q): %s", test.constraint, e)
+			continue
+		}
+		for _, v := range test.matches {
+			if !c.Check(MustParse(v)) {
+				t.Errorf("%q does not match %s", test.constraint, v)
+			}
+		}
+		for _, v := range test.rejects {
+			if c.Check(MustParse(v)) {
+				t.Errorf("%q matches %s", test.constraint, v)
+			}
+		}
+	}
+}
+
+func TestParseConstraintRejectsInvalidInput(t *testing.T) {
+	for _, s := range []string{"", "||", "1.2 ||", ">=", "~", ">=1.x", "^1.*", "1.2 < abc", "=>1.2"} {
+		if _, e := ParseConstraint(s); e == nil {
+			t.Errorf("ParseConstraint(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestAllowsSeries(t *testing.T) {
+	tests := []struct {
+		constraint string
+		allows     []string
+		rejects    []string
+	}{
+		{"~1.21.3", []string{"1.21", "1"}, []string{"1.20", "1.22", "2"}},
+		{">=1.20 <1.23", []string{"1.20", "1.22"}, []string{"1.19", "1.23"}},
+		{"^0.2.3", []string{"0.2"}, []string{"0.1", "0.3"}},
+		{"1.22.3 || 1.24.x", []string{"1.22", "1.24"}, []string{"1.23", "1.25"}},
+		{"*", []string{"1", "1.22"}, nil},
+	}
+	for _, test := range tests {
+		c := MustParseConstraint(test.constraint)
+		for _, s := range test.allows {
+			if !c.AllowsSeries(MustParse(s)) {
+				t.Errorf("%q does not allow the series %s", test.constraint, s)
+			}
+		}
+		for _, s := range test.rejects {
+			if c.AllowsSeries(MustParse(s)) {
+				t.Errorf("%q allows the series %s", test.constraint, s)
+			}
+		}
+	}
+}
+
+func TestIsConstraint(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"1.22.3", false},
+		{"1.22rc1", false},
+		{"1.22", false},
+		{"1.22.x", true},
+		{"~1.21", true},
+		{"^1", true},
+		{">=1.20 <1.23", true},
+		{"1 || 2", true},
+		{"*", true},
+	}
+	for _, test := range tests {
+		if got := IsConstraint(test.in); got != test.want {
+			t.Errorf("IsConstraint(%q) = %v, want %v", test.in, got, test.want)
+		}
+	}
+}
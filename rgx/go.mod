@@ -3,13 +3,17 @@ module rgx
 go 1.22.1
 
 require (
-	github.com/itchyny/gojq v0.12.15
+	github.com/ProtonMail/go-crypto v1.1.6
+	github.com/klauspost/compress v1.18.0
 	github.com/pelletier/go-toml/v2 v2.2.2
 	github.com/spf13/cobra v1.8.0
+	github.com/ulikunitz/xz v0.5.17
+	golang.org/x/crypto v0.27.0
+	golang.org/x/sys v0.25.0
 )
 
 require (
+	github.com/cloudflare/circl v1.3.7 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/itchyny/timefmt-go v0.1.5 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
 )
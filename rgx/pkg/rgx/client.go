@@ -0,0 +1,178 @@
+// Package rgx lets Go programs install and locate packages the way the rgx command does.
+//
+//	client, e := rgx.NewClient(config)
+//	p, e := client.Install(ctx, "golang", "1.22", false)
+//	gobin, e := client.Which("golang", "go")
+package rgx
+
+import (
+	"context"
+	"time"
+
+	"rgx/candidates"
+	"rgx/common/http"
+	"rgx/common/utils"
+)
+
+type (
+	ServerPackage     = candidates.ServerPackage
+	Resolution        = candidates.Resolution
+	InstalledPackage  = candidates.InstalledPackage
+	InstalledArtifact = candidates.InstalledArtifact
+	CurrentVersion    = candidates.CurrentVersion
+	OutdatedPackage   = candidates.OutdatedPackage
+	CacheEntry        = candidates.CacheEntry
+)
+
+// The errors returned by a Client wrap one of these, see errors.Is
+var (
+	ErrConfig            = utils.ErrConfig
+	ErrServerUnavailable = http.ErrServerUnavailable
+	ErrBadResponse       = http.ErrBadResponse
+	ErrPackageNotFound   = candidates.ErrPackageNotFound
+	ErrNoMatchingVersion = candidates.ErrNoMatchingVersion
+	ErrNotInstalled      = candidates.ErrNotInstalled
+	ErrChecksumMismatch  = utils.ErrChecksumMismatch
+	// ErrUnsupportedChecksum and ErrUnverified are wrapped together with ErrChecksumMismatch
+	ErrUnsupportedChecksum = utils.ErrUnsupportedChecksum
+	ErrUnverified          = utils.ErrUnverified
+	ErrExtract             = utils.ErrExtract
+	ErrScript              = utils.ErrScript
+	ErrSignature           = utils.ErrSignature
+	ErrRegistry            = candidates.ErrRegistry
+	ErrUnsafePath          = candidates.ErrUnsafePath
+)
+
+// Client runs commands with its own configuration, several clients can be used at once
+type Client struct {
+	config utils.RgxConfig
+}
+
+// ReadConfig reads rgx.toml from $RGX_CONFIG_DIR, or from the directory of the executable
+func ReadConfig() (utils.RgxConfig, error) {
+	return utils.ReadConfig()
+}
+
+// NewClient creates the directories named in config if they don't exist yet
+func NewClient(config utils.RgxConfig) (*Client, error) {
+	c := &Client{config: config}
+	if e := utils.Configure(&c.config); e != nil {
+		return nil, e
+	}
+	return c, nil
+}
+
+func (c *Client) Config() utils.RgxConfig {
+	return c.config
+}
+
+// ListServerPackages lists the packages the server can install
+func (c *Client) ListServerPackages(ctx context.Context) ([]ServerPackage, error) {
+	return candidates.ServerPackages(ctx, &c.config)
+}
+
+// Versions lists the major versions of pkg, or with all, every release the server has for this
+// platform. If the server can't list every release of pkg, the major versions are returned.
+func (c *Client) Versions(ctx context.Context, pkg string, lts, all bool) ([]string, error) {
+	if all {
+		releases, ok, e := candidates.Releases(ctx, &c.config, pkg, lts)
+		if e != nil || ok {
+			return releases, e
+		}
+	}
+	return candidates.MajorVersions(ctx, &c.config, pkg, lts)
+}
+
+// Resolve finds the release that installing version of pkg would install. version may be an
+// exact release, a major version, an alias such as latest or lts, a wildcard or a range.
+func (c *Client) Resolve(ctx context.Context, pkg, version string, lts bool) (Resolution, error) {
+	return candidates.Resolve(ctx, &c.config, pkg, version, lts)
+}
+
+func (c *Client) Install(ctx context.Context, pkg, version string, lts bool) (InstalledPackage, error) {
+	return candidates.Install(ctx, &c.config, pkg, version, lts)
+}
+
+// InstallProject installs the packages the .rgx-versions file of the current directory or its
+// parents lists
+func (c *Client) InstallProject(ctx context.Context, lts bool) ([]InstalledPackage, error) {
+	return candidates.InstallProject(ctx, &c.config, lts)
+}
+
+// InstallFrozen installs exactly what rgx.lock records, and nothing if the server's recipes
+// changed since
+func (c *Client) InstallFrozen(ctx context.Context) ([]InstalledPackage, error) {
+	return candidates.InstallFrozen(ctx, &c.config)
+}
+
+// Lock resolves the packages in .rgx-versions to exact recipes and writes rgx.lock next to it
+func (c *Client) Lock(ctx context.Context, lts bool) error {
+	return candidates.Lock(ctx, &c.config, lts)
+}
+
+// Outdated lists the installs of pkg, or of every package if pkg is empty, that have a newer
+// release of their major version on the server
+func (c *Client) Outdated(ctx context.Context, pkg string) ([]OutdatedPackage, error) {
+	return candidates.Outdated(ctx, &c.config, pkg)
+}
+
+// Upgrade installs the newest release of each outdated install and returns those it upgraded.
+// With prune, the releases they replace are uninstalled.
+func (c *Client) Upgrade(ctx context.Context, pkg string, prune bool) ([]OutdatedPackage, error) {
+	return candidates.Upgrade(ctx, &c.config, pkg, prune)
+}
+
+// Installed lists the installed packages, ordered by package and version
+func (c *Client) Installed() ([]InstalledPackage, error) {
+	return candidates.Installed(&c.config)
+}
+
+// Uninstall removes an installed version of pkg, which may be an exact or a major version
+func (c *Client) Uninstall(pkg, version string) error {
+	_, e := candidates.Uninstall(&c.config, pkg, version, false)
+	return e
+}
+
+// UninstallDryRun returns the paths Uninstall would remove
+func (c *Client) UninstallDryRun(pkg, version string) ([]string, error) {
+	return candidates.Uninstall(&c.config, pkg, version, true)
+}
+
+// Use makes an installed version of pkg the active one
+func (c *Client) Use(pkg, version string) error {
+	return candidates.Use(&c.config, pkg, version)
+}
+
+// Current lists the version in use of pkg, or of every active or pinned package if pkg is empty
+func (c *Client) Current(pkg string) ([]CurrentVersion, error) {
+	return candidates.Current(&c.config, pkg)
+}
+
+// Which returns the full path of a binary of pkg, from the version pinned for the current
+// directory, the active version or the highest installed version, in that order
+func (c *Client) Which(pkg, binary string) (string, error) {
+	return candidates.Which(&c.config, pkg, binary)
+}
+
+// CacheEntries lists the files in the download cache, most recently used first
+func (c *Client) CacheEntries() ([]CacheEntry, error) {
+	return candidates.CacheEntries(&c.config)
+}
+
+// CacheSize returns the number of files in the download cache and their total size
+func (c *Client) CacheSize() (int, int64, error) {
+	return candidates.CacheSize(&c.config)
+}
+
+// CleanCache removes the files from the download cache last used more than olderThan ago, or
+// all of them if olderThan is 0, and returns them. With keepInstalled, files that installed
+// packages came from are kept.
+func (c *Client) CleanCache(olderThan time.Duration, keepInstalled bool) ([]CacheEntry, error) {
+	return candidates.CleanCache(&c.config, olderThan, keepInstalled)
+}
+
+// VerifyCache checks the files in the download cache against their checksums, and removes and
+// returns those that don't match
+func (c *Client) VerifyCache(ctx context.Context) (int, []CacheEntry, error) {
+	return candidates.VerifyCache(ctx, &c.config)
+}